@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+	"github.com/tidwall/gjson"
+)
+
+// ConfigMapReference points to a key of a ConfigMap holding trusted
+// registries, one entry per line. Blank lines and lines starting with '#'
+// are ignored.
+type ConfigMapReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+}
+
+func (r ConfigMapReference) String() string {
+	return fmt.Sprintf("%s/%s[%s]", r.Namespace, r.Name, r.Key)
+}
+
+func (r ConfigMapReference) validate() error {
+	if !isDNSSubdomain(r.Namespace) {
+		return fmt.Errorf("invalid namespace '%s'", r.Namespace)
+	}
+	if !isDNSSubdomain(r.Name) {
+		return fmt.Errorf("invalid name '%s'", r.Name)
+	}
+	if !isConfigMapKey(r.Key) {
+		return fmt.Errorf("invalid key '%s': only alphanumerics, '-', '_' and '.' are allowed", r.Key)
+	}
+	return nil
+}
+
+// isConfigMapKey reports whether the key is a valid ConfigMap data key.
+func isConfigMapKey(key string) bool {
+	if key == "" || key == "." || key == ".." || len(key) > maxSecretNameLength {
+		return false
+	}
+	for i := range len(key) {
+		c := key[i]
+		if !isLowerAlphaNumeric(c) && (c < 'A' || c > 'Z') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchConfigMapEntries returns the entries listed under the key of the
+// ConfigMap, fetched through the Kubernetes capability of the host.
+func fetchConfigMapEntries(h *capabilities.Host, ref ConfigMapReference) ([]string, error) {
+	if h.Client == nil {
+		return nil, errors.New("host capabilities are not available")
+	}
+	configMap, err := kubernetes.GetResource(h, kubernetes.GetResourceRequest{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       ref.Name,
+		Namespace:  &ref.Namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(configMap) {
+		return nil, errors.New("host returned an invalid ConfigMap")
+	}
+	content, found := stringMap(gjson.GetBytes(configMap, "data"))[ref.Key]
+	if !found {
+		return nil, fmt.Errorf("key '%s' not found", ref.Key)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// loadConfigMapRegistries merges the trusted registries of the ConfigMap
+// into the top level trusted registries, when they apply to the request.
+// The ConfigMap only adds trust, so when it cannot be read, or some of its
+// entries are invalid, the static list applies alone and a warning is
+// logged.
+func (s *Settings) loadConfigMapRegistries(ctx requestContext) {
+	if s.TrustedRegistriesConfigMap == nil {
+		return
+	}
+	if _, namespaceRule := s.trustRulesFor(ctx); namespaceRule != nil {
+		return
+	}
+
+	ref := *s.TrustedRegistriesConfigMap
+	entries, err := fetchConfigMapEntries(&host, ref)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Cannot read the trusted registries of ConfigMap %s, using the static list only: %v", ref, err))
+		return
+	}
+	for _, entry := range entries {
+		rules, err := compileTrustRules([]string{entry}, nil)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Ignoring invalid trusted registry of ConfigMap %s: %v", ref, err))
+			continue
+		}
+		s.defaultRules.registries = append(s.defaultRules.registries, rules.registries...)
+		s.defaultRules.templates = append(s.defaultRules.templates, rules.templates...)
+	}
+	logger.Debug(fmt.Sprintf("Loaded %d trusted registries from ConfigMap %s", len(entries), ref))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+)
+
+const registriesConfigMapKey = "ConfigMap/kubewarden/trusted-registries"
+
+func configMapWithData(data map[string]string) map[string]any {
+	return map[string]any{"data": data}
+}
+
+func TestValidateConfigMapRegistries(t *testing.T) {
+	client := newFakeHost()
+	client.resources[registriesConfigMapKey] = configMapWithData(map[string]string{
+		"registries.txt": "# approved by the security team\nquay.io/ourorg\n\nghcr.io/Invalid\n  gcr.io/{{namespace}}/  \n",
+	})
+
+	cases := []struct {
+		namespace string
+		image     string
+		expected  bool
+	}{
+		{namespace: "default", image: "registry.corp/app:1.0", expected: true},
+		{namespace: "default", image: "quay.io/ourorg/app:1.0", expected: true},
+		{namespace: "default", image: "gcr.io/default/app:1.0", expected: true},
+		{namespace: "default", image: "gcr.io/other/app:1.0"},
+		{namespace: "default", image: "ghcr.io/invalid/app:1.0"},
+		{namespace: "kube-system", image: "quay.io/ourorg/app:1.0"},
+	}
+
+	for _, testCase := range cases {
+		useFakeHost(t, client)
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+			TrustedRegistriesConfigMap: &ConfigMapReference{
+				Namespace: "kubewarden",
+				Name:      "trusted-registries",
+				Key:       "registries.txt",
+			},
+			NamespaceRules: []NamespaceRule{{Namespaces: []string{"kube-system"}, TrustedRegistries: []string{"registry.k8s.io"}}},
+		}
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{testCase.image}, &settings)
+		if response.Accepted != testCase.expected {
+			t.Errorf("Namespace %s, image %s: expected accepted=%v, got %v (%v)",
+				testCase.namespace, testCase.image, testCase.expected, response.Accepted, response.Message)
+		}
+	}
+	if calls := client.calls[registriesConfigMapKey]; calls != 5 {
+		t.Errorf("Expected the ConfigMap to be read once per request outside namespace rules, got %d", calls)
+	}
+}
+
+func TestValidateConfigMapRegistriesUnavailable(t *testing.T) {
+	cases := []struct {
+		name      string
+		configMap any
+		err       error
+	}{
+		{name: "missing ConfigMap"},
+		{name: "missing key", configMap: configMapWithData(map[string]string{"other.txt": "quay.io/ourorg"})},
+		{name: "host error", err: errors.New("api server unreachable")},
+	}
+
+	for _, testCase := range cases {
+		client := newFakeHost()
+		if testCase.configMap != nil {
+			client.resources[registriesConfigMapKey] = testCase.configMap
+		}
+		if testCase.err != nil {
+			client.errs[registriesConfigMapKey] = testCase.err
+		}
+		useFakeHost(t, client)
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+			TrustedRegistriesConfigMap: &ConfigMapReference{
+				Namespace: "kubewarden",
+				Name:      "trusted-registries",
+				Key:       "registries.txt",
+			},
+		}
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodImages(t, []string{"registry.corp/app:1.0"}, &settings)
+		if !response.Accepted {
+			t.Errorf("%s: expected the static list to apply, got %v", testCase.name, *response.Message)
+		}
+		response = validatePodImages(t, []string{"quay.io/ourorg/app:1.0"}, &settings)
+		if response.Accepted {
+			t.Errorf("%s: unexpected acceptance", testCase.name)
+		}
+	}
+}
+
+func TestFetchConfigMapEntries(t *testing.T) {
+	ref := ConfigMapReference{Namespace: "kubewarden", Name: "trusted-registries", Key: "registries.txt"}
+	client := newFakeHost()
+	client.resources[registriesConfigMapKey] = configMapWithData(map[string]string{"registries.txt": "quay.io/ourorg\r\n# comment\n\ngcr.io\n"})
+
+	entries, err := fetchConfigMapEntries(&capabilities.Host{Client: client}, ref)
+	if err != nil || len(entries) != 2 || entries[0] != "quay.io/ourorg" || entries[1] != "gcr.io" {
+		t.Errorf("Unexpected entries %q (%v)", entries, err)
+	}
+	if _, err = fetchConfigMapEntries(&capabilities.Host{}, ref); err == nil {
+		t.Errorf("Expected an error without host client")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// containerType tells which list of the pod spec a container belongs to.
+type containerType string
+
+const (
+	containerTypeContainer containerType = "container"
+	containerTypeInit      containerType = "init"
+	containerTypeEphemeral containerType = "ephemeral"
+)
+
+// containerLists maps every container list of a pod spec to the type of the
+// containers it holds, in the order they are reported.
+func containerLists() []struct {
+	field         string
+	containerType containerType
+} {
+	return []struct {
+		field         string
+		containerType containerType
+	}{
+		{"containers", containerTypeContainer},
+		{"initContainers", containerTypeInit},
+		// Ephemeral containers are added by `kubectl debug` through the
+		// pods/ephemeralcontainers subresource on UPDATE.
+		{"ephemeralContainers", containerTypeEphemeral},
+	}
+}
+
+// container is a container of the pod spec under evaluation.
+type container struct {
+	Name  string
+	Type  containerType
+	Image string
+}
+
+// key identifies the container within its pod spec, so that it can be
+// matched across the old and new objects of an update.
+func (c container) key() string {
+	return string(c.Type) + "/" + c.Name
+}
+
+func (c container) String() string {
+	switch c.Type {
+	case containerTypeInit:
+		return fmt.Sprintf("init container '%s'", c.Name)
+	case containerTypeEphemeral:
+		return fmt.Sprintf("ephemeral container '%s'", c.Name)
+	case containerTypeContainer:
+	}
+	return fmt.Sprintf("container '%s'", c.Name)
+}
+
+// getContainers returns the containers with an image from every container
+// list of the pod spec.
+func getContainers(podSpec gjson.Result) []container {
+	var containers []container
+	for _, list := range containerLists() {
+		podSpec.Get(list.field).ForEach(func(_, value gjson.Result) bool {
+			if img := value.Get("image").String(); img != "" {
+				containers = append(containers, container{
+					Name:  value.Get("name").String(),
+					Type:  list.containerType,
+					Image: img,
+				})
+			}
+			return true
+		})
+	}
+	return containers
+}
+
+// previousImages returns the images of the containers of the old object of
+// UPDATE requests, keyed by container, and nil for other operations.
+func previousImages(validationRequest gjson.Result, podSpecPath string) map[string]string {
+	if validationRequest.Get("request.operation").String() != "UPDATE" {
+		return nil
+	}
+	oldPodSpec := validationRequest.Get("request.oldObject" + strings.TrimPrefix(podSpecPath, "request.object"))
+	images := map[string]string{}
+	for _, c := range getContainers(oldPodSpec) {
+		images[c.key()] = c.Image
+	}
+	return images
+}
+
+// violation records why the image of a container is not allowed.
+type violation struct {
+	Container container
+	// Reference is the normalized reference that was evaluated. It is empty
+	// when the image could not be parsed.
+	Reference string
+	Reason    string
+}
+
+func (v violation) String() string {
+	image := fmt.Sprintf("'%s'", v.Container.Image)
+	if v.Reference != "" && v.Reference != v.Container.Image {
+		image = fmt.Sprintf("'%s' (evaluated as '%s')", v.Container.Image, v.Reference)
+	}
+	return fmt.Sprintf("%s: image %s %s", v.Container, image, v.Reason)
+}
+
+// formatViolations renders all the violations in a single rejection message.
+func formatViolations(violations []violation) string {
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		messages = append(messages, v.String())
+	}
+	if len(messages) == 1 {
+		return messages[0]
+	}
+	return fmt.Sprintf("%d images are not allowed: %s", len(messages), strings.Join(messages, "; "))
+}
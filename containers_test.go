@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestGetContainers(t *testing.T) {
+	podSpec := gjson.Parse(`{
+		"containers": [
+			{"name": "app", "image": "quay.io/some/app:1.0"},
+			{"name": "no-image"}
+		],
+		"initContainers": [{"name": "setup", "image": "busybox"}],
+		"ephemeralContainers": [{"name": "debugger", "image": "quay.io/tools/debug"}]
+	}`)
+
+	expected := []container{
+		{Name: "app", Type: containerTypeContainer, Image: "quay.io/some/app:1.0"},
+		{Name: "setup", Type: containerTypeInit, Image: "busybox"},
+		{Name: "debugger", Type: containerTypeEphemeral, Image: "quay.io/tools/debug"},
+	}
+
+	containers := getContainers(podSpec)
+	if len(containers) != len(expected) {
+		t.Fatalf("Expected %d containers, got %d: %+v", len(expected), len(containers), containers)
+	}
+	for i := range expected {
+		if containers[i] != expected[i] {
+			t.Errorf("Expected container %+v, got %+v", expected[i], containers[i])
+		}
+	}
+}
+
+func TestFormatViolations(t *testing.T) {
+	single := []violation{
+		{
+			Container: container{Name: "app", Type: containerTypeContainer, Image: "nginx"},
+			Reference: "docker.io/library/nginx:latest",
+			Reason:    "is not from a trusted registry",
+		},
+	}
+	expected := "container 'app': image 'nginx' (evaluated as 'docker.io/library/nginx:latest') is not from a trusted registry"
+	if got := formatViolations(single); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	multiple := append(single, violation{
+		Container: container{Name: "setup", Type: containerTypeInit, Image: "gcr.io/init:1.0"},
+		Reference: "gcr.io/init:1.0",
+		Reason:    "is not from a trusted registry",
+	})
+	expected = "2 images are not allowed: " + expected +
+		"; init container 'setup': image 'gcr.io/init:1.0' is not from a trusted registry"
+	if got := formatViolations(multiple); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
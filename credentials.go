@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	secretTypeDockerConfigJSON = "kubernetes.io/dockerconfigjson"
+	secretTypeDockerConfig     = "kubernetes.io/dockercfg"
+	dockerConfigJSONKey        = ".dockerconfigjson"
+	dockerConfigKey            = ".dockercfg"
+)
+
+// compileCredentialRegistries validates the registries whose images need
+// credentials. Credentials are matched by registry host, so entries must
+// not have a repository path.
+func compileCredentialRegistries(entries []string) (mapset.Set[string], error) {
+	registries := mapset.NewThreadUnsafeSetWithSize[string](len(entries))
+	for _, entry := range entries {
+		registry := normalizeRegistryEntry(entry)
+		if strings.ContainsAny(registry, "/"+segmentWildcard) {
+			return nil, fmt.Errorf("invalid registry '%s': must be a registry host", entry)
+		}
+		if err := validateDomain(registry); err != nil {
+			return nil, fmt.Errorf("invalid registry '%s': %w", entry, err)
+		}
+		registries.Add(registry)
+	}
+	return registries, nil
+}
+
+// podSpecPullSecrets returns the names of the pull secrets of the pod spec.
+func podSpecPullSecrets(podSpec gjson.Result) []string {
+	var names []string
+	for _, name := range podSpec.Get("imagePullSecrets.#.name").Array() {
+		if name.String() != "" {
+			names = append(names, name.String())
+		}
+	}
+	return names
+}
+
+// credentialResolver collects the registry hosts the pull secrets of a pod
+// and of its service account have credentials for. Secrets are only read
+// through the Kubernetes capability of the host the first time an image
+// needs credentials, and once per request.
+type credentialResolver struct {
+	host   *capabilities.Host
+	ctx    requestContext
+	loaded bool
+	hosts  mapset.Set[string]
+	err    error
+}
+
+func newCredentialResolver(h *capabilities.Host, ctx requestContext) *credentialResolver {
+	return &credentialResolver{host: h, ctx: ctx}
+}
+
+// credentialHosts returns the registry hosts with credentials, along with
+// the errors of the secrets that could not be read.
+func (r *credentialResolver) credentialHosts() (mapset.Set[string], error) {
+	if !r.loaded {
+		r.hosts, r.err = r.load()
+		r.loaded = true
+	}
+	return r.hosts, r.err
+}
+
+func (r *credentialResolver) load() (mapset.Set[string], error) {
+	hosts := mapset.NewThreadUnsafeSet[string]()
+	if r.host.Client == nil {
+		return hosts, errors.New("host capabilities are not available")
+	}
+
+	// The pull secrets of the service account are only added to pods when
+	// they are created, so they are read as well to check pod templates.
+	secrets := mapset.NewThreadUnsafeSet[string](r.ctx.PullSecrets...)
+	var errs []error
+	serviceAccount, err := r.get("ServiceAccount", r.ctx.ServiceAccount)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("service account '%s': %w", r.ctx.ServiceAccount, err))
+	} else {
+		secrets.Append(podSpecPullSecrets(serviceAccount)...)
+	}
+
+	for _, name := range sortedSlice(secrets) {
+		secret, err := r.get("Secret", name)
+		if err == nil {
+			var secretHosts []string
+			secretHosts, err = dockerConfigHosts(secret)
+			hosts.Append(secretHosts...)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secret '%s': %w", name, err))
+		}
+	}
+	return hosts, errors.Join(errs...)
+}
+
+func (r *credentialResolver) get(kind, name string) (gjson.Result, error) {
+	resource, err := kubernetes.GetResource(r.host, kubernetes.GetResourceRequest{
+		APIVersion: "v1",
+		Kind:       kind,
+		Name:       name,
+		Namespace:  &r.ctx.Namespace,
+	})
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	if !gjson.ValidBytes(resource) {
+		return gjson.Result{}, fmt.Errorf("host returned an invalid %s", kind)
+	}
+	return gjson.ParseBytes(resource), nil
+}
+
+// dockerConfigHosts returns the registry hosts a pull secret has
+// credentials for.
+func dockerConfigHosts(secret gjson.Result) ([]string, error) {
+	data := stringMap(secret.Get("data"))
+	var (
+		encoded string
+		path    string
+	)
+	switch secretType := secret.Get("type").String(); secretType {
+	case secretTypeDockerConfigJSON:
+		encoded, path = data[dockerConfigJSONKey], "auths"
+	case secretTypeDockerConfig:
+		encoded, path = data[dockerConfigKey], "@this"
+	default:
+		return nil, fmt.Errorf("type '%s' is not a docker config", secretType)
+	}
+
+	config, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode docker config: %w", err)
+	}
+	if !gjson.ValidBytes(config) {
+		return nil, errors.New("docker config is not valid JSON")
+	}
+	var hosts []string
+	gjson.GetBytes(config, path).ForEach(func(key, _ gjson.Result) bool {
+		hosts = append(hosts, normalizeCredentialHost(key.String()))
+		return true
+	})
+	return hosts, nil
+}
+
+// normalizeCredentialHost returns the registry host of a docker config
+// entry, which may be written as a URL such as "https://index.docker.io/v1/".
+func normalizeCredentialHost(entry string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(entry, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	host = strings.ToLower(host)
+	if host == legacyDefaultDomain {
+		return defaultDomain
+	}
+	return host
+}
+
+// checkCredentials returns why the image violates the credential check, if
+// its registry requires credentials that neither the pod nor its service
+// account have.
+func (s *Settings) checkCredentials(ref imageReference, resolver *credentialResolver) (string, bool) {
+	if s.credentialRegistries == nil || !s.credentialRegistries.Contains(ref.Registry) {
+		return "", false
+	}
+	hosts, err := resolver.credentialHosts()
+	if hosts.Contains(ref.Registry) {
+		return "", false
+	}
+	if err != nil {
+		return fmt.Sprintf("has no credentials for registry '%s', some pull secrets cannot be read: %v", ref.Registry, err), true
+	}
+	return fmt.Sprintf("has no credentials for registry '%s' in the pull secrets of the pod or of service account '%s'",
+		ref.Registry, resolver.ctx.ServiceAccount), true
+}
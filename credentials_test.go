@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+	"github.com/tidwall/gjson"
+)
+
+func dockerConfigSecret(hosts ...string) map[string]any {
+	auths := map[string]any{}
+	for _, host := range hosts {
+		auths[host] = map[string]string{"auth": base64.StdEncoding.EncodeToString([]byte("user:password"))}
+	}
+	config, _ := json.Marshal(map[string]any{"auths": auths})
+	return map[string]any{
+		"type": secretTypeDockerConfigJSON,
+		"data": map[string]string{dockerConfigJSONKey: base64.StdEncoding.EncodeToString(config)},
+	}
+}
+
+func serviceAccountWithPullSecrets(names ...string) map[string]any {
+	secrets := []map[string]string{}
+	for _, name := range names {
+		secrets = append(secrets, map[string]string{"name": name})
+	}
+	return map[string]any{"imagePullSecrets": secrets}
+}
+
+// validatePodWithPullSecrets runs the policy against a pod of the "apps"
+// namespace with the given image, service account and pull secrets.
+func validatePodWithPullSecrets(
+	t *testing.T, image, serviceAccount string, pullSecrets []string, settings *Settings,
+) kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	name := "app"
+	pod := corev1.Pod{
+		Metadata: &metav1.ObjectMeta{Name: "test-pod", Namespace: "apps"},
+		Spec: &corev1.PodSpec{
+			Containers:         []*corev1.Container{{Name: &name, Image: image}},
+			ServiceAccountName: serviceAccount,
+		},
+	}
+	for _, secret := range pullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, &corev1.LocalObjectReference{Name: secret})
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequest(&pod, settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	return validatePayload(t, payload)
+}
+
+func TestValidateCredentials(t *testing.T) {
+	client := newFakeHost()
+	client.resources["ServiceAccount/apps/default"] = serviceAccountWithPullSecrets()
+	client.resources["ServiceAccount/apps/builder"] = serviceAccountWithPullSecrets("corp-pull")
+	client.resources["Secret/apps/corp-pull"] = dockerConfigSecret("https://registry.corp/v1/")
+	client.resources["Secret/apps/hub-pull"] = dockerConfigSecret("https://index.docker.io/v1/")
+	client.resources["Secret/apps/token"] = map[string]any{"type": "kubernetes.io/service-account-token"}
+	client.errs["ServiceAccount/apps/broken"] = errors.New("api server unreachable")
+
+	cases := []struct {
+		image           string
+		serviceAccount  string
+		pullSecrets     []string
+		expectedMessage string
+	}{
+		{image: "registry.corp/app:1.0", pullSecrets: []string{"corp-pull"}},
+		{image: "registry.corp/app:1.0", serviceAccount: "builder"},
+		{image: "nginx:1.27", pullSecrets: []string{"hub-pull"}},
+		{image: "quay.io/ourorg/app:1.0"},
+		{
+			image:           "registry.corp/app:1.0",
+			pullSecrets:     []string{"hub-pull"},
+			expectedMessage: "container 'app': image 'registry.corp/app:1.0' has no credentials for registry 'registry.corp' in the pull secrets of the pod or of service account 'default'",
+		},
+		{
+			image:       "registry.corp/app:1.0",
+			pullSecrets: []string{"token"},
+			expectedMessage: "container 'app': image 'registry.corp/app:1.0' has no credentials for registry 'registry.corp', " +
+				"some pull secrets cannot be read: secret 'token': type 'kubernetes.io/service-account-token' is not a docker config",
+		},
+		{image: "registry.corp/app:1.0", serviceAccount: "broken", pullSecrets: []string{"corp-pull"}},
+		{
+			image:          "registry.corp/app:1.0",
+			serviceAccount: "broken",
+			expectedMessage: "container 'app': image 'registry.corp/app:1.0' has no credentials for registry 'registry.corp', " +
+				"some pull secrets cannot be read: service account 'broken': api server unreachable",
+		},
+	}
+
+	for _, testCase := range cases {
+		useFakeHost(t, client)
+		settings := Settings{
+			TrustedRegistries:   mapset.NewThreadUnsafeSet[string]("registry.corp", "docker.io", "quay.io"),
+			RequiresCredentials: mapset.NewThreadUnsafeSet[string]("registry.corp", "index.docker.io"),
+		}
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodWithPullSecrets(t, testCase.image, testCase.serviceAccount, testCase.pullSecrets, &settings)
+		if response.Accepted != (testCase.expectedMessage == "") {
+			t.Errorf("Image %s with %v: unexpected accepted=%v (%v)", testCase.image, testCase.pullSecrets, response.Accepted, response.Message)
+			continue
+		}
+		if !response.Accepted && *response.Message != testCase.expectedMessage {
+			t.Errorf("Expected message %q, got %q", testCase.expectedMessage, *response.Message)
+		}
+	}
+}
+
+func TestValidateCredentialsOfAllowedImages(t *testing.T) {
+	client := newFakeHost()
+	client.resources["ServiceAccount/apps/default"] = serviceAccountWithPullSecrets()
+	client.resources["Secret/apps/vendor-pull"] = dockerConfigSecret("vendor.example.com")
+	image := "vendor.example.com/hsm@" + approvedDigest
+	allowlisted := Settings{
+		RequiresCredentials: mapset.NewThreadUnsafeSet[string]("vendor.example.com"),
+		DigestAllowlists:    []DigestAllowlist{{Namespaces: []string{"apps"}, Digests: []string{approvedDigest}}},
+	}
+	excepted := Settings{
+		RequiresCredentials: mapset.NewThreadUnsafeSet[string]("vendor.example.com"),
+		ImageExceptions:     []ImageException{{Image: image, Reason: "HSM is only published on the vendor registry", Owner: "platform-team"}},
+	}
+
+	cases := []struct {
+		name            string
+		settings        Settings
+		pullSecrets     []string
+		expectedMessage string
+	}{
+		{name: "allowlist", settings: allowlisted, pullSecrets: []string{"vendor-pull"}},
+		{
+			name:     "allowlist",
+			settings: allowlisted,
+			expectedMessage: "container 'app': image '" + image + "' has no credentials for registry 'vendor.example.com' " +
+				"in the pull secrets of the pod or of service account 'default'",
+		},
+		{name: "exception", settings: excepted, pullSecrets: []string{"vendor-pull"}},
+		{
+			name:     "exception",
+			settings: excepted,
+			expectedMessage: "container 'app': image '" + image + "' has no credentials for registry 'vendor.example.com' " +
+				"in the pull secrets of the pod or of service account 'default'",
+		},
+	}
+
+	for _, testCase := range cases {
+		useFakeHost(t, client)
+		settings := testCase.settings
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodWithPullSecrets(t, image, "", testCase.pullSecrets, &settings)
+		if response.Accepted != (testCase.expectedMessage == "") {
+			t.Errorf("%s with %v: unexpected accepted=%v (%v)", testCase.name, testCase.pullSecrets, response.Accepted, response.Message)
+			continue
+		}
+		if !response.Accepted && *response.Message != testCase.expectedMessage {
+			t.Errorf("Expected message %q, got %q", testCase.expectedMessage, *response.Message)
+		}
+	}
+}
+
+func TestValidateDoesNotReadSecretsWithoutCredentialRegistries(t *testing.T) {
+	client := newFakeHost()
+	useFakeHost(t, client)
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+	}
+
+	response := validatePodWithPullSecrets(t, "registry.corp/app:1.0", "", []string{"corp-pull"}, &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %v", *response.Message)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("Expected no host call, got %v", client.calls)
+	}
+}
+
+func TestDockerConfigHosts(t *testing.T) {
+	legacyConfig := base64.StdEncoding.EncodeToString([]byte(`{"quay.io": {"auth": "dXNlcjpwYXNzd29yZA=="}}`))
+	cases := []struct {
+		secret        any
+		expectedHosts []string
+		expectedError bool
+	}{
+		{secret: dockerConfigSecret("https://index.docker.io/v1/", "Registry.Corp:5000"), expectedHosts: []string{"docker.io", "registry.corp:5000"}},
+		{
+			secret:        map[string]any{"type": secretTypeDockerConfig, "data": map[string]string{dockerConfigKey: legacyConfig}},
+			expectedHosts: []string{"quay.io"},
+		},
+		{secret: map[string]any{"type": secretTypeDockerConfigJSON, "data": map[string]string{dockerConfigJSONKey: "not base64"}}, expectedError: true},
+		{secret: map[string]any{"type": "Opaque"}, expectedError: true},
+	}
+
+	for _, testCase := range cases {
+		raw, err := json.Marshal(testCase.secret)
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		hosts, err := dockerConfigHosts(gjson.ParseBytes(raw))
+		if (err != nil) != testCase.expectedError {
+			t.Errorf("Secret %s: unexpected error %v", raw, err)
+			continue
+		}
+		if !mapset.NewThreadUnsafeSet[string](hosts...).Equal(mapset.NewThreadUnsafeSet[string](testCase.expectedHosts...)) {
+			t.Errorf("Secret %s: expected hosts %v, got %v", raw, testCase.expectedHosts, hosts)
+		}
+	}
+}
+
+func TestInjectedPullSecretsSatisfyCredentials(t *testing.T) {
+	client := newFakeHost()
+	client.resources["ServiceAccount/apps/default"] = serviceAccountWithPullSecrets()
+	client.resources["Secret/apps/corp-pull"] = dockerConfigSecret("registry.corp")
+	useFakeHost(t, client)
+	settings := Settings{
+		TrustedRegistries:   mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		RequiresCredentials: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		Mutate:              true,
+		PullSecrets:         map[string]string{"registry.corp": "corp-pull"},
+	}
+	if err := settings.compile(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	response := validatePodWithPullSecrets(t, "registry.corp/app:1.0", "", nil, &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %v", *response.Message)
+	}
+	if client.calls["Secret/apps/corp-pull"] != 1 {
+		t.Errorf("Expected the injected pull secret to be read, got %v", client.calls)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// DigestAllowlist restricts the images of the namespaces it selects to a list
+// of approved digests, regardless of the registry they come from. Entries
+// are either a digest, approved from any repository, or a reference such as
+// "registry.corp/app@sha256:...", approving the digest for that repository
+// only.
+type DigestAllowlist struct {
+	// Namespaces are exact namespace names or globs using `*`.
+	Namespaces []string `json:"namespaces"`
+	Digests    []string `json:"digests"`
+
+	// digests and references hold the approved digests and the approved
+	// "<name>@<digest>" references, so that lookups do not depend on the
+	// length of the list.
+	digests    mapset.Set[string]
+	references mapset.Set[string]
+}
+
+// compile validates the allowlist and builds its lookup sets.
+func (a *DigestAllowlist) compile() error {
+	if len(a.Namespaces) == 0 {
+		return errors.New("no namespaces provided")
+	}
+	for _, selector := range a.Namespaces {
+		if err := validateNamespaceSelector(selector); err != nil {
+			return err
+		}
+	}
+	if len(a.Digests) == 0 {
+		return errors.New("no digests provided")
+	}
+
+	a.digests = mapset.NewThreadUnsafeSetWithSize[string](len(a.Digests))
+	a.references = mapset.NewThreadUnsafeSet[string]()
+	for _, entry := range a.Digests {
+		if !strings.Contains(entry, "@") {
+			if err := validateDigest(entry); err != nil {
+				return err
+			}
+			a.digests.Add(entry)
+			continue
+		}
+		ref, err := parseNormalizedImageReference(entry)
+		if err != nil {
+			return fmt.Errorf("invalid entry '%s': %w", entry, err)
+		}
+		a.references.Add(digestKey(ref))
+	}
+	return nil
+}
+
+// selects reports whether the allowlist applies to the namespace.
+func (a *DigestAllowlist) selects(namespace string) bool {
+	_, selected := matchAny(a.Namespaces, namespace)
+	return selected
+}
+
+// allows reports whether the digest of the normalized image is approved.
+func (a *DigestAllowlist) allows(ref imageReference) bool {
+	if ref.Digest == "" {
+		return false
+	}
+	return a.digests.Contains(ref.Digest) || a.references.Contains(digestKey(ref))
+}
+
+// digestKey identifies an image by name and digest, ignoring its tag.
+func digestKey(ref imageReference) string {
+	return ref.Name() + "@" + ref.Digest
+}
+
+// digestAllowlistFor returns the first digest allowlist selecting the
+// namespace.
+func (s *Settings) digestAllowlistFor(namespace string) (*DigestAllowlist, bool) {
+	for i := range s.DigestAllowlists {
+		if s.DigestAllowlists[i].selects(namespace) {
+			return &s.DigestAllowlists[i], true
+		}
+	}
+	return nil, false
+}
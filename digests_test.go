@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+const (
+	approvedDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	paymentsDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+)
+
+func TestDigestAllowlistAllows(t *testing.T) {
+	allowlist := DigestAllowlist{
+		Namespaces: []string{"pci-*"},
+		Digests:    []string{approvedDigest, "registry.corp/payments@" + paymentsDigest},
+	}
+	if err := allowlist.compile(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	cases := []struct {
+		image         string
+		expectedAllow bool
+	}{
+		{"registry.corp/app@" + approvedDigest, true},
+		{"quay.io/other/app:1.0@" + approvedDigest, true},
+		{"registry.corp/payments@" + paymentsDigest, true},
+		{"registry.corp/payments:2.0@" + paymentsDigest, true},
+		{"registry.corp/payments-debug@" + paymentsDigest, false},
+		{"registry.corp/payments:2.0", false},
+		{"registry.corp/app:1.0", false},
+	}
+
+	for _, testCase := range cases {
+		ref, err := parseNormalizedImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		if allows := allowlist.allows(ref); allows != testCase.expectedAllow {
+			t.Errorf("Image %s: expected allowed=%v, got %v", testCase.image, testCase.expectedAllow, allows)
+		}
+	}
+}
+
+func TestDigestAllowlistWithThousandsOfEntries(t *testing.T) {
+	allowlist := DigestAllowlist{Namespaces: []string{"pci"}}
+	for i := range 5000 {
+		allowlist.Digests = append(allowlist.Digests, fmt.Sprintf("sha256:%064x", i))
+	}
+	if err := allowlist.compile(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if allowlist.digests.Cardinality() != 5000 {
+		t.Errorf("Expected 5000 digests, got %d", allowlist.digests.Cardinality())
+	}
+
+	ref, err := parseNormalizedImageReference(fmt.Sprintf("registry.corp/app@sha256:%064x", 4999))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !allowlist.allows(ref) {
+		t.Errorf("Expected the last digest to be allowed")
+	}
+}
+
+func TestValidateDigestAllowlists(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		Blocked:           mapset.NewThreadUnsafeSet[string]("quay.io/compromised"),
+		DigestAllowlists: []DigestAllowlist{
+			{Namespaces: []string{"pci-*"}, Digests: []string{approvedDigest}},
+		},
+	}
+
+	cases := []struct {
+		namespace       string
+		image           string
+		expectedMessage string
+	}{
+		{"pci-payments", "vendor.example.com/hsm@" + approvedDigest, ""},
+		{
+			"pci-payments", "registry.corp/app:1.0",
+			"container 'container-0': image 'registry.corp/app:1.0' is not in the digest allowlist of namespace 'pci-payments'",
+		},
+		{
+			"pci-payments", "registry.corp/app@" + paymentsDigest,
+			"container 'container-0': image 'registry.corp/app@" + paymentsDigest +
+				"' is not in the digest allowlist of namespace 'pci-payments'",
+		},
+		{
+			"pci-payments", "quay.io/compromised/app@" + approvedDigest,
+			"container 'container-0': image 'quay.io/compromised/app@" + approvedDigest + "' is blocked by 'quay.io/compromised'",
+		},
+		{"default", "registry.corp/app:1.0", ""},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{testCase.image}, &settings)
+		if testCase.expectedMessage == "" {
+			if !response.Accepted {
+				t.Errorf("Unexpected rejection of image %s: %v", testCase.image, *response.Message)
+			}
+			continue
+		}
+		if response.Accepted {
+			t.Errorf("Unexpected acceptance of image %s", testCase.image)
+			continue
+		}
+		if *response.Message != testCase.expectedMessage {
+			t.Errorf("Image %s: expected message %q, got %q", testCase.image, testCase.expectedMessage, *response.Message)
+		}
+	}
+}
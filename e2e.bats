@@ -55,10 +55,81 @@
   [ $(expr "$output" : '.*allowed.*false') -ne 0 ]
 }
 
+@test "reject when a deployment template uses an untrusted image" {
+  # The init container of the deployment comes from gcr.io, which is not trusted
+  run kwctl run -r test_data/deployment.json \
+    --settings-json '{"trusted_registries": ["quay.io"]}' \
+    policy.wasm
+
+  # Print the output if any check fails
+  echo "output = ${output}"
+
+  # Check if the deployment is rejected at creation time
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*false') -ne 0 ]
+}
+
+@test "accept when a cronjob template only uses trusted images" {
+  run kwctl run -r test_data/cronjob.json \
+    --settings-json '{"trusted_registries": ["quay.io", "gcr.io"]}' \
+    policy.wasm
+
+  # Print the output if any check fails
+  echo "output = ${output}"
+
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*true') -ne 0 ]
+}
+
+@test "reject when kubectl debug injects an untrusted ephemeral container" {
+  # The pods/ephemeralcontainers subresource UPDATE adds a docker.io image
+  run kwctl run -r test_data/pod-ephemeral-containers.json \
+    --settings-json '{"trusted_registries": ["quay.io"]}' \
+    policy.wasm
+
+  # Print the output if any check fails
+  echo "output = ${output}"
+
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*false') -ne 0 ]
+}
+
+@test "reject when a pod update swaps in an untrusted image" {
+  # kubectl set image changes spec.containers[*].image of the running pod
+  run kwctl run -r test_data/pod-update-image.json \
+    --settings-json '{"trusted_registries": ["quay.io"]}' \
+    policy.wasm
+
+  # Print the output if any check fails
+  echo "output = ${output}"
 
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*false') -ne 0 ]
+}
 
+@test "accept a pod update that leaves untrusted images unchanged" {
+  # Only a label is added to a running pod admitted before the settings changed
+  run kwctl run -r test_data/pod-update-labels.json \
+    --settings-json '{"trusted_registries": ["quay.io"]}' \
+    policy.wasm
 
+  # Print the output if any check fails
+  echo "output = ${output}"
 
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*true') -ne 0 ]
+}
 
+@test "mutate images to an approved mirror" {
+  # The docker.io image is rewritten to the trusted mirror instead of rejected
+  run kwctl run -r test_data/pod-untrusted.json \
+    --settings-json '{"trusted_registries": ["quay.io", "mirror.corp"], "mutate": true, "mirrors": {"docker.io": "mirror.corp/dockerhub"}}' \
+    policy.wasm
 
+  # Print the output if any check fails
+  echo "output = ${output}"
 
+  [ "$status" -eq 0 ]
+  [ $(expr "$output" : '.*allowed.*true') -ne 0 ]
+  [ $(expr "$output" : '.*patchType.*JSONPatch') -ne 0 ]
+}
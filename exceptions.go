@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImageException allows a single image even though it is not from a trusted
+// registry, for example a vendor appliance only published on the vendor
+// registry. Exceptions match exactly: an image reference must be equal to the
+// one of the container once both are normalized, and a digest must be equal
+// to the digest of the container image.
+type ImageException struct {
+	// Image is the exact image reference, for example
+	// "vendor.example.com/appliance@sha256:...".
+	Image string `json:"image,omitempty"`
+	// Digest allows the image with the digest from any repository.
+	Digest string `json:"digest,omitempty"`
+	// Reason is the mandatory justification of the exception.
+	Reason string `json:"reason"`
+	// Owner is the person or team responsible for the exception.
+	Owner string `json:"owner,omitempty"`
+	// Namespaces are exact namespace names or globs using `*` the exception
+	// is limited to. The exception applies to every namespace when empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Expires is the RFC 3339 timestamp after which the exception is
+	// ignored. The exception does not expire when empty.
+	Expires string `json:"expires,omitempty"`
+
+	ref     imageReference
+	expires time.Time
+}
+
+func (e *ImageException) String() string {
+	if e.Image != "" {
+		return e.Image
+	}
+	return e.Digest
+}
+
+// compile validates the exception and parses its reference and expiry.
+func (e *ImageException) compile() error {
+	switch {
+	case e.Image != "" && e.Digest != "":
+		return errors.New("only one of image and digest must be provided")
+	case e.Image != "":
+		ref, err := parseNormalizedImageReference(e.Image)
+		if err != nil {
+			return fmt.Errorf("invalid image '%s': %w", e.Image, err)
+		}
+		e.ref = ref
+	case e.Digest != "":
+		if err := validateDigest(e.Digest); err != nil {
+			return err
+		}
+	default:
+		return errors.New("no image or digest provided")
+	}
+
+	if strings.TrimSpace(e.Reason) == "" {
+		return fmt.Errorf("exception for '%s' has no reason", e)
+	}
+	for _, selector := range e.Namespaces {
+		if err := validateNamespaceSelector(selector); err != nil {
+			return err
+		}
+	}
+	if e.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, e.Expires)
+		if err != nil {
+			return fmt.Errorf("invalid expiry of '%s': %w", e, err)
+		}
+		e.expires = expires
+	}
+	return nil
+}
+
+// matches reports whether the exception applies to the normalized image in
+// the namespace. Expired exceptions never match.
+func (e *ImageException) matches(ref imageReference, namespace string) bool {
+	if e.Image != "" && ref != e.ref {
+		return false
+	}
+	if e.Digest != "" && ref.Digest != e.Digest {
+		return false
+	}
+	if len(e.Namespaces) > 0 {
+		if _, selected := matchAny(e.Namespaces, namespace); !selected {
+			return false
+		}
+	}
+	if !e.expires.IsZero() && !e.expires.After(timeNow()) {
+		logger.Warn(fmt.Sprintf("Ignoring image exception %s owned by %s: expired at %s", e, e.Owner, e.Expires))
+		return false
+	}
+	return true
+}
+
+// findImageException returns the first image exception allowing the image
+// in the namespace.
+func (s *Settings) findImageException(ref imageReference, namespace string) (*ImageException, bool) {
+	for i := range s.ImageExceptions {
+		if s.ImageExceptions[i].matches(ref, namespace) {
+			return &s.ImageExceptions[i], true
+		}
+	}
+	return nil, false
+}
+
+// logImageException records the exception allowing the image of the
+// container, along with its justification.
+func logImageException(c container, ref imageReference, e *ImageException) {
+	logger.InfoWith(fmt.Sprintf("Image %s of %s allowed by image exception %s", ref, c, e)).
+		String("exception", e.String()).
+		String("reason", e.Reason).
+		String("owner", e.Owner).
+		Write()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+const applianceDigest = "sha256:0d3e1b5c5b2f6a4a7c8e9f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e"
+
+func TestImageExceptionMatches(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+
+	cases := []struct {
+		name            string
+		exception       ImageException
+		image           string
+		namespace       string
+		expectedMatches bool
+	}{
+		{
+			name:            "exact reference",
+			exception:       ImageException{Image: "vendor.example.com/appliance@" + applianceDigest, Reason: "vendor appliance"},
+			image:           "vendor.example.com/appliance@" + applianceDigest,
+			expectedMatches: true,
+		},
+		{
+			name:            "other repository of the same registry",
+			exception:       ImageException{Image: "vendor.example.com/appliance@" + applianceDigest, Reason: "vendor appliance"},
+			image:           "vendor.example.com/appliance-debug@" + applianceDigest,
+			expectedMatches: false,
+		},
+		{
+			name:            "reference is not a prefix",
+			exception:       ImageException{Image: "vendor.example.com/appliance", Reason: "vendor appliance"},
+			image:           "vendor.example.com/appliance/agent:latest",
+			expectedMatches: false,
+		},
+		{
+			name:            "references are compared once normalized",
+			exception:       ImageException{Image: "docker.io/library/busybox:1.36", Reason: "debugging"},
+			image:           "busybox:1.36",
+			expectedMatches: true,
+		},
+		{
+			name:            "other tag",
+			exception:       ImageException{Image: "vendor.example.com/appliance:1.0", Reason: "vendor appliance"},
+			image:           "vendor.example.com/appliance:1.1",
+			expectedMatches: false,
+		},
+		{
+			name:            "digest from any repository",
+			exception:       ImageException{Digest: applianceDigest, Reason: "vendor appliance"},
+			image:           "mirror.example.com/vendor/appliance:2.0@" + applianceDigest,
+			expectedMatches: true,
+		},
+		{
+			name:            "digest does not match tags",
+			exception:       ImageException{Digest: applianceDigest, Reason: "vendor appliance"},
+			image:           "vendor.example.com/appliance:2.0",
+			expectedMatches: false,
+		},
+		{
+			name: "selected namespace",
+			exception: ImageException{
+				Image: "vendor.example.com/appliance:1.0", Reason: "vendor appliance", Namespaces: []string{"vendor-*"},
+			},
+			image:           "vendor.example.com/appliance:1.0",
+			namespace:       "vendor-appliance",
+			expectedMatches: true,
+		},
+		{
+			name: "other namespace",
+			exception: ImageException{
+				Image: "vendor.example.com/appliance:1.0", Reason: "vendor appliance", Namespaces: []string{"vendor-*"},
+			},
+			image:           "vendor.example.com/appliance:1.0",
+			namespace:       "default",
+			expectedMatches: false,
+		},
+		{
+			name: "not expired",
+			exception: ImageException{
+				Image: "vendor.example.com/appliance:1.0", Reason: "vendor appliance", Expires: "2026-12-31T00:00:00Z",
+			},
+			image:           "vendor.example.com/appliance:1.0",
+			expectedMatches: true,
+		},
+		{
+			name: "expired",
+			exception: ImageException{
+				Image: "vendor.example.com/appliance:1.0", Reason: "vendor appliance", Expires: "2026-10-01T00:00:00Z",
+			},
+			image:           "vendor.example.com/appliance:1.0",
+			expectedMatches: false,
+		},
+	}
+
+	for _, testCase := range cases {
+		exception := testCase.exception
+		if err := exception.compile(); err != nil {
+			t.Errorf("%s: unexpected error: %+v", testCase.name, err)
+			continue
+		}
+		ref, err := parseNormalizedImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %+v", testCase.name, err)
+			continue
+		}
+		namespace := testCase.namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		if matches := exception.matches(ref, namespace); matches != testCase.expectedMatches {
+			t.Errorf("%s: expected matches=%v, got %v", testCase.name, testCase.expectedMatches, matches)
+		}
+	}
+}
+
+func TestValidateImageExceptions(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		Blocked:           mapset.NewThreadUnsafeSet[string]("quay.io/banned"),
+		ImageExceptions: []ImageException{
+			{
+				Image:      "vendor.example.com/appliance@" + applianceDigest,
+				Reason:     "Appliance is only published on the vendor registry",
+				Owner:      "platform-team",
+				Namespaces: []string{"appliance"},
+			},
+			{
+				Digest: applianceDigest,
+				Reason: "Digest allowed everywhere for the test",
+				Owner:  "platform-team",
+			},
+		},
+	}
+
+	cases := []struct {
+		namespace       string
+		image           string
+		expectedIsValid bool
+	}{
+		{"appliance", "vendor.example.com/appliance@" + applianceDigest, true},
+		{"default", "vendor.example.com/appliance@" + applianceDigest, true},
+		{"appliance", "vendor.example.com/appliance:latest", false},
+		{"appliance", "vendor.example.com/other:latest", false},
+		{"default", "quay.io/banned/app@" + applianceDigest, false},
+		{"default", "quay.io/some/app:1.0", true},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{testCase.image}, &settings)
+		if response.Accepted != testCase.expectedIsValid {
+			t.Errorf("Image %s in namespace %s: expected accepted=%v, got %v (%v)", testCase.image,
+				testCase.namespace, testCase.expectedIsValid, response.Accepted, response.Message)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+	breakGlassExpiryAnnotation = "trusted-registry.kubewarden.io/exempt-until"
+	breakGlassReasonAnnotation = "trusted-registry.kubewarden.io/exempt-reason"
+)
+
+// timeNow is the time source of the policy.
+//
+//nolint:gochecknoglobals // Tests replace it to fake the current time.
+var timeNow = time.Now
+
+// exemption describes why a request is accepted without evaluating its
+// images.
+type exemption struct {
+	// Kind is the setting the exemption comes from: namespace, user, group
+	// or service account.
+	Kind string
+	// Subject is the namespace, user, group or service account of the
+	// request that matched.
+	Subject string
+	// Rule is the matching settings entry.
+	Rule string
+}
+
+// logExemption records the exemption so that exempted requests can be told
+// apart from requests whose images passed the checks.
+func logExemption(e exemption) {
+	logger.InfoWith(fmt.Sprintf("Request exempted by %s rule %s", e.Kind, e.Rule)).
+		String("exemption", e.Kind).
+		String("subject", e.Subject).
+		String("rule", e.Rule).
+		Write()
+}
+
+// requestNamespace returns the namespace of the admission request, falling
+// back to the namespace of the object when the request does not carry one.
+func requestNamespace(validationRequest gjson.Result) string {
+	if namespace := validationRequest.Get("request.namespace").String(); namespace != "" {
+		return namespace
+	}
+	return validationRequest.Get("request.object.metadata.namespace").String()
+}
+
+// findExemption returns the first exemption applying to the request, looking
+// at its namespace and at the user that made it.
+func (s *Settings) findExemption(validationRequest gjson.Result) (exemption, bool) {
+	namespace := requestNamespace(validationRequest)
+	if selector, exempted := s.namespaceExemption(namespace); exempted {
+		return exemption{Kind: "namespace", Subject: namespace, Rule: selector}, true
+	}
+
+	username := validationRequest.Get("request.userInfo.username").String()
+	if rule, exempted := matchAny(s.exemptUsers, username); exempted {
+		return exemption{Kind: "user", Subject: username, Rule: rule}, true
+	}
+	if serviceAccount, found := strings.CutPrefix(username, serviceAccountUsernamePrefix); found {
+		if rule, exempted := matchAny(s.exemptServiceAccounts, serviceAccount); exempted {
+			return exemption{Kind: "service account", Subject: serviceAccount, Rule: rule}, true
+		}
+	}
+	for _, group := range validationRequest.Get("request.userInfo.groups").Array() {
+		if rule, exempted := matchAny(s.exemptGroups, group.String()); exempted {
+			return exemption{Kind: "group", Subject: group.String(), Rule: rule}, true
+		}
+	}
+
+	// Break-glass annotations are read from the pod template of workloads,
+	// so that the pods their controllers create are exempted as well.
+	metadataPath, found := podMetadataPath(validationRequest.Get("request.kind.kind").String())
+	if !found {
+		return exemption{}, false
+	}
+	return s.breakGlassExemption(validationRequest.Get(metadataPath + ".annotations"))
+}
+
+// breakGlassExemption honors the break-glass annotations of the pods: an
+// expiry timestamp, which must be in the future and within the configured
+// maximum window, and a mandatory reason. Break-glass is disabled unless the
+// maximum window is configured.
+func (s *Settings) breakGlassExemption(annotations gjson.Result) (exemption, bool) {
+	if s.breakGlassMaxDuration == 0 {
+		return exemption{}, false
+	}
+	values := stringMap(annotations)
+	until, found := values[breakGlassExpiryAnnotation]
+	if !found {
+		return exemption{}, false
+	}
+
+	reason := strings.TrimSpace(values[breakGlassReasonAnnotation])
+	if reason == "" {
+		logger.Warn(fmt.Sprintf("Ignoring break-glass annotation %s: annotation %s is missing",
+			breakGlassExpiryAnnotation, breakGlassReasonAnnotation))
+		return exemption{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Ignoring break-glass annotation %s: %v", breakGlassExpiryAnnotation, err))
+		return exemption{}, false
+	}
+	now := timeNow()
+	if !expiry.After(now) {
+		logger.Warn(fmt.Sprintf("Ignoring break-glass annotation %s: expired at %s", breakGlassExpiryAnnotation, until))
+		return exemption{}, false
+	}
+	if expiry.Sub(now) > s.breakGlassMaxDuration {
+		logger.Warn(fmt.Sprintf("Ignoring break-glass annotation %s: %s is more than %s away",
+			breakGlassExpiryAnnotation, until, s.breakGlassMaxDuration))
+		return exemption{}, false
+	}
+
+	return exemption{
+		Kind:    "break-glass annotation",
+		Subject: reason,
+		Rule:    fmt.Sprintf("%s=%s", breakGlassExpiryAnnotation, until),
+	}, true
+}
+
+// namespaceExemption returns the exempt_namespaces entry selecting the
+// namespace, if any.
+func (s *Settings) namespaceExemption(namespace string) (string, bool) {
+	return matchAny(s.exemptNamespaces, namespace)
+}
+
+// matchAny returns the first glob matching the value. Globs use `*` to match
+// any sequence of characters.
+func matchAny(globs []string, value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	for _, glob := range globs {
+		if matchSegment(glob, value) {
+			return glob, true
+		}
+	}
+	return "", false
+}
+
+// compileExemptions validates the exemption settings and sorts them, so that
+// the rule reported for an exemption does not depend on the set iteration
+// order.
+func (s *Settings) compileExemptions() error {
+	s.exemptNamespaces = sortedSlice(s.ExemptNamespaces)
+	for _, selector := range s.exemptNamespaces {
+		if err := validateNamespaceSelector(selector); err != nil {
+			return fmt.Errorf("exempt_namespaces: %w", err)
+		}
+	}
+
+	s.exemptUsers = sortedSlice(s.ExemptUsers)
+	for _, user := range s.exemptUsers {
+		if strings.Trim(user, segmentWildcard) == "" {
+			return errors.New("exempt_users: entries must not be empty or bare wildcards")
+		}
+	}
+
+	s.exemptGroups = sortedSlice(s.ExemptGroups)
+	for _, group := range s.exemptGroups {
+		if strings.Trim(group, segmentWildcard) == "" {
+			return errors.New("exempt_groups: entries must not be empty or bare wildcards")
+		}
+	}
+
+	s.exemptServiceAccounts = sortedSlice(s.ExemptServiceAccounts)
+	for _, serviceAccount := range s.exemptServiceAccounts {
+		namespace, name, found := strings.Cut(serviceAccount, ":")
+		if !found || strings.Contains(name, ":") {
+			return fmt.Errorf("exempt_service_accounts: invalid entry '%s': must be in the form '<namespace>:<name>'", serviceAccount)
+		}
+		if err := validateNamespaceSelector(namespace); err != nil {
+			return fmt.Errorf("exempt_service_accounts: invalid entry '%s': %w", serviceAccount, err)
+		}
+		if name == "" {
+			return fmt.Errorf("exempt_service_accounts: invalid entry '%s': the name must not be empty", serviceAccount)
+		}
+	}
+
+	if s.BreakGlassMaxDuration != "" {
+		duration, err := time.ParseDuration(s.BreakGlassMaxDuration)
+		if err != nil {
+			return fmt.Errorf("break_glass_max_duration: %w", err)
+		}
+		if duration <= 0 {
+			return errors.New("break_glass_max_duration: must be positive")
+		}
+		s.breakGlassMaxDuration = duration
+	}
+
+	return nil
+}
+
+func sortedSlice(set mapset.Set[string]) []string {
+	values := toSlice(set)
+	sort.Strings(values)
+	return values
+}
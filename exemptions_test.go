@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/tidwall/gjson"
+)
+
+func TestNamespaceExemption(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		ExemptNamespaces:  mapset.NewThreadUnsafeSet[string]("kube-system", "cni-*"),
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		namespace        string
+		expectedSelector string
+		expectedExempted bool
+	}{
+		{"kube-system", "kube-system", true},
+		{"cni-calico", "cni-*", true},
+		{"kube-system-2", "", false},
+		{"default", "", false},
+		{"", "", false},
+	}
+
+	for _, testCase := range cases {
+		selector, exempted := settings.namespaceExemption(testCase.namespace)
+		if exempted != testCase.expectedExempted || selector != testCase.expectedSelector {
+			t.Errorf("Namespace %q: expected (%q, %v), got (%q, %v)", testCase.namespace,
+				testCase.expectedSelector, testCase.expectedExempted, selector, exempted)
+		}
+	}
+}
+
+func TestValidateAcceptsExemptNamespaces(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		ExemptNamespaces:  mapset.NewThreadUnsafeSet[string]("kube-system", "csi-*"),
+	}
+
+	cases := []struct {
+		namespace       string
+		expectedIsValid bool
+	}{
+		{"kube-system", true},
+		{"csi-ceph", true},
+		{"default", false},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{"vendor.example.com/cni:1.0"}, &settings)
+		if response.Accepted != testCase.expectedIsValid {
+			t.Errorf("Namespace %s: expected accepted=%v, got %v", testCase.namespace,
+				testCase.expectedIsValid, response.Accepted)
+		}
+	}
+}
+
+func TestFindExemptionFromUserInfo(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries:     mapset.NewThreadUnsafeSet[string]("quay.io"),
+		ExemptUsers:           mapset.NewThreadUnsafeSet[string]("admin@corp.example", "breakglass-*"),
+		ExemptGroups:          mapset.NewThreadUnsafeSet[string]("system:masters"),
+		ExemptServiceAccounts: mapset.NewThreadUnsafeSet[string]("ci:bootstrap", "flux-*:*"),
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		username          string
+		groups            string
+		expectedExemption exemption
+		expectedExempted  bool
+	}{
+		{
+			username:          "admin@corp.example",
+			groups:            `["system:authenticated"]`,
+			expectedExemption: exemption{Kind: "user", Subject: "admin@corp.example", Rule: "admin@corp.example"},
+			expectedExempted:  true,
+		},
+		{
+			username:          "breakglass-jane",
+			groups:            `[]`,
+			expectedExemption: exemption{Kind: "user", Subject: "breakglass-jane", Rule: "breakglass-*"},
+			expectedExempted:  true,
+		},
+		{
+			username:          "kubernetes-admin",
+			groups:            `["system:masters", "system:authenticated"]`,
+			expectedExemption: exemption{Kind: "group", Subject: "system:masters", Rule: "system:masters"},
+			expectedExempted:  true,
+		},
+		{
+			username:          "system:serviceaccount:ci:bootstrap",
+			groups:            `["system:serviceaccounts"]`,
+			expectedExemption: exemption{Kind: "service account", Subject: "ci:bootstrap", Rule: "ci:bootstrap"},
+			expectedExempted:  true,
+		},
+		{
+			username:          "system:serviceaccount:flux-system:kustomize-controller",
+			groups:            `[]`,
+			expectedExemption: exemption{Kind: "service account", Subject: "flux-system:kustomize-controller", Rule: "flux-*:*"},
+			expectedExempted:  true,
+		},
+		{
+			username:         "system:serviceaccount:ci:deployer",
+			groups:           `["system:serviceaccounts", "system:authenticated"]`,
+			expectedExempted: false,
+		},
+		{
+			username:         "developer@corp.example",
+			groups:           `["developers"]`,
+			expectedExempted: false,
+		},
+	}
+
+	for _, testCase := range cases {
+		validationRequest := gjson.Parse(fmt.Sprintf(
+			`{"request": {"namespace": "default", "userInfo": {"username": %q, "groups": %s}}}`,
+			testCase.username, testCase.groups))
+
+		got, exempted := settings.findExemption(validationRequest)
+		if exempted != testCase.expectedExempted || got != testCase.expectedExemption {
+			t.Errorf("User %q: expected (%+v, %v), got (%+v, %v)", testCase.username,
+				testCase.expectedExemption, testCase.expectedExempted, got, exempted)
+		}
+	}
+}
+
+func TestValidateAcceptsExemptUsers(t *testing.T) {
+	payload := func(username string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"request": {
+				"kind": {"group": "", "version": "v1", "kind": "Pod"},
+				"namespace": "default",
+				"userInfo": {"username": %q, "groups": ["system:authenticated"]},
+				"object": {"spec": {"containers": [{"name": "app", "image": "vendor.example.com/app:1.0"}]}}
+			},
+			"settings": {"trusted_registries": ["quay.io"], "exempt_service_accounts": ["ci:bootstrap"]}
+		}`, username))
+	}
+
+	if response := validatePayload(t, payload("system:serviceaccount:ci:bootstrap")); !response.Accepted {
+		t.Errorf("Unexpected rejection of an exempt service account: %s", *response.Message)
+	}
+	if response := validatePayload(t, payload("system:serviceaccount:default:bootstrap")); response.Accepted {
+		t.Errorf("Unexpected acceptance of a service account that is not exempt")
+	}
+}
+
+func TestBreakGlassAnnotations(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+
+	payload := func(maxDuration, annotations string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"request": {
+				"kind": {"group": "", "version": "v1", "kind": "Pod"},
+				"namespace": "default",
+				"object": {
+					"metadata": {"name": "test-pod", "annotations": %s},
+					"spec": {"containers": [{"name": "app", "image": "vendor.example.com/app:1.0"}]}
+				}
+			},
+			"settings": {"trusted_registries": ["quay.io"], "break_glass_max_duration": %q}
+		}`, annotations, maxDuration))
+	}
+
+	cases := []struct {
+		name            string
+		maxDuration     string
+		annotations     string
+		expectedIsValid bool
+	}{
+		{
+			name:            "expiry in the future with a reason",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-20T00:00:00Z", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234 vendor hotfix"}`,
+			expectedIsValid: true,
+		},
+		{
+			name:            "expiry with a timezone offset",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-18T16:00:00+02:00", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`,
+			expectedIsValid: true,
+		},
+		{
+			name:            "break-glass disabled",
+			maxDuration:     "",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-20T00:00:00Z", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "missing reason",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-20T00:00:00Z"}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "blank reason",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-20T00:00:00Z", "trusted-registry.kubewarden.io/exempt-reason": " "}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "expired",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-18T11:59:59Z", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "beyond the maximum window",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "2026-11-01T00:00:00Z", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "malformed expiry",
+			maxDuration:     "72h",
+			annotations:     `{"trusted-registry.kubewarden.io/exempt-until": "next week", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`,
+			expectedIsValid: false,
+		},
+		{
+			name:            "no annotations",
+			maxDuration:     "72h",
+			annotations:     `{}`,
+			expectedIsValid: false,
+		},
+	}
+
+	for _, testCase := range cases {
+		response := validatePayload(t, payload(testCase.maxDuration, testCase.annotations))
+		if response.Accepted != testCase.expectedIsValid {
+			t.Errorf("%s: expected accepted=%v, got %v", testCase.name, testCase.expectedIsValid, response.Accepted)
+		}
+	}
+}
+
+func TestBreakGlassAnnotationsOfWorkloads(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+
+	const annotations = `{"trusted-registry.kubewarden.io/exempt-until": "2026-10-20T00:00:00Z", "trusted-registry.kubewarden.io/exempt-reason": "INC-1234"}`
+	const podTemplate = `{"metadata": {"annotations": %s}, "spec": {"containers": [{"name": "app", "image": "vendor.example.com/app:1.0"}]}}`
+	payload := func(kind, object string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"request": {
+				"kind": {"group": "apps", "version": "v1", "kind": %q},
+				"namespace": "default",
+				"object": %s
+			},
+			"settings": {"trusted_registries": ["quay.io"], "break_glass_max_duration": "72h"}
+		}`, kind, object))
+	}
+
+	cases := []struct {
+		name            string
+		kind            string
+		object          string
+		expectedIsValid bool
+	}{
+		{
+			name:            "deployment with an annotated pod template",
+			kind:            "Deployment",
+			object:          fmt.Sprintf(`{"metadata": {"name": "app"}, "spec": {"template": `+podTemplate+`}}`, annotations),
+			expectedIsValid: true,
+		},
+		{
+			name:            "cronjob with an annotated pod template",
+			kind:            "CronJob",
+			object:          fmt.Sprintf(`{"metadata": {"name": "app"}, "spec": {"jobTemplate": {"spec": {"template": `+podTemplate+`}}}}`, annotations),
+			expectedIsValid: true,
+		},
+		{
+			// The pods created by the controller would not be exempted.
+			name:            "deployment annotated outside of its pod template",
+			kind:            "Deployment",
+			object:          fmt.Sprintf(`{"metadata": {"name": "app", "annotations": %s}, "spec": {"template": `+podTemplate+`}}`, annotations, `{}`),
+			expectedIsValid: false,
+		},
+	}
+
+	for _, testCase := range cases {
+		response := validatePayload(t, payload(testCase.kind, testCase.object))
+		if response.Accepted != testCase.expectedIsValid {
+			t.Errorf("%s: expected accepted=%v, got %v", testCase.name, testCase.expectedIsValid, response.Accepted)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+// fakeHost answers the host callbacks of the capabilities the policy uses.
+// Calls are counted by key, and the error set for a key is returned
+// instead of a result:
+//   - OCI manifest digests, keyed by image.
+//   - Sigstore verifications, keyed by "<type>|<image>". An image is trusted
+//     by a type of verification when it is signed for it.
+//   - Kubernetes resources, keyed by "<kind>/<name>" for cluster-wide
+//     resources and "<kind>/<namespace>/<name>" otherwise.
+type fakeHost struct {
+	digests   map[string]string
+	signed    map[string]mapset.Set[string]
+	resources map[string]any
+	errs      map[string]error
+	calls     map[string]int
+}
+
+func newFakeHost() *fakeHost {
+	return &fakeHost{
+		digests:   map[string]string{},
+		signed:    map[string]mapset.Set[string]{},
+		resources: map[string]any{},
+		errs:      map[string]error{},
+		calls:     map[string]int{},
+	}
+}
+
+func (h *fakeHost) sign(verificationType string, images ...string) {
+	if _, found := h.signed[verificationType]; !found {
+		h.signed[verificationType] = mapset.NewThreadUnsafeSet[string]()
+	}
+	h.signed[verificationType].Append(images...)
+}
+
+func (h *fakeHost) HostCall(binding, namespace, operation string, payload []byte) ([]byte, error) {
+	switch {
+	case binding == "kubewarden" && namespace == "oci" && operation == "v1/manifest_digest":
+		return h.manifestDigest(payload)
+	case binding == "kubewarden" && namespace == "oci" && operation == "v2/verify":
+		return h.verify(payload)
+	case binding == "kubewarden" && namespace == "kubernetes" && operation == "get_resource":
+		return h.getResource(payload)
+	}
+	return nil, fmt.Errorf("unexpected host call %s/%s/%s", binding, namespace, operation)
+}
+
+func (h *fakeHost) call(key string) error {
+	h.calls[key]++
+	return h.errs[key]
+}
+
+func (h *fakeHost) manifestDigest(payload []byte) ([]byte, error) {
+	var image string
+	if err := json.Unmarshal(payload, &image); err != nil {
+		return nil, err
+	}
+	if err := h.call(image); err != nil {
+		return nil, err
+	}
+	digest, found := h.digests[image]
+	if !found {
+		return nil, fmt.Errorf("manifest unknown: %s", image)
+	}
+	return json.Marshal(map[string]string{"digest": digest})
+}
+
+func (h *fakeHost) verify(payload []byte) ([]byte, error) {
+	request := struct {
+		Type  string `json:"type"`
+		Image string `json:"image"`
+	}{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+	if err := h.call(request.Type + "|" + request.Image); err != nil {
+		return nil, err
+	}
+	signed, found := h.signed[request.Type]
+	return json.Marshal(map[string]any{"is_trusted": found && signed.Contains(request.Image), "digest": ""})
+}
+
+func (h *fakeHost) getResource(payload []byte) ([]byte, error) {
+	request := kubernetes.GetResourceRequest{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+	key := request.Kind + "/" + request.Name
+	if request.Namespace != nil {
+		key = request.Kind + "/" + *request.Namespace + "/" + request.Name
+	}
+	if err := h.call(key); err != nil {
+		return nil, err
+	}
+	resource, found := h.resources[key]
+	if !found {
+		return nil, fmt.Errorf("%s not found", key)
+	}
+	return json.Marshal(resource)
+}
+
+// useFakeHost routes the host callbacks of the test to the client.
+func useFakeHost(t *testing.T, client capabilities.WapcClient) {
+	t.Helper()
+
+	previous := host
+	host = capabilities.Host{Client: client}
+	t.Cleanup(func() { host = previous })
+}
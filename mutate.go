@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	"github.com/tidwall/gjson"
+)
+
+// mirrorRule rewrites the images whose normalized name starts with the
+// source prefix to the mirror prefix.
+type mirrorRule struct {
+	source string
+	mirror string
+}
+
+// compileMirrors validates the mirrors setting. Rules are sorted from the
+// longest source prefix to the shortest, so that the most specific prefix
+// wins regardless of the order of the map. Mirrors must not live under any
+// source, so that rewriting is idempotent: the pods created from a
+// rewritten workload, and reinvocations of the webhook, are left untouched.
+func compileMirrors(mirrors map[string]string) ([]mirrorRule, error) {
+	rules := make([]mirrorRule, 0, len(mirrors))
+	for source, mirror := range mirrors {
+		rule := mirrorRule{source: normalizeRegistryEntry(source), mirror: strings.TrimSuffix(mirror, "/")}
+		if err := validateRegistryPrefix(rule.source); err != nil {
+			return nil, fmt.Errorf("invalid source '%s': %w", source, err)
+		}
+		if err := validateRegistryPrefix(rule.mirror); err != nil {
+			return nil, fmt.Errorf("invalid mirror '%s': %w", mirror, err)
+		}
+		if registry, _ := splitDomain(rule.mirror + "/"); registry == "" {
+			return nil, fmt.Errorf("invalid mirror '%s': must start with a registry host", mirror)
+		}
+		rules = append(rules, rule)
+	}
+	for _, rule := range rules {
+		for _, other := range rules {
+			if hasNamePrefix(normalizeRegistryEntry(rule.mirror), other.source) {
+				return nil, fmt.Errorf("invalid mirror '%s': lives under source '%s', so mirrored images would be rewritten again",
+					rule.mirror, other.source)
+			}
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].source) != len(rules[j].source) {
+			return len(rules[i].source) > len(rules[j].source)
+		}
+		return rules[i].source < rules[j].source
+	})
+	return rules, nil
+}
+
+// validateRegistryPrefix checks that the prefix is a registry, optionally
+// followed by repository path components, without wildcards.
+func validateRegistryPrefix(prefix string) error {
+	if strings.Contains(prefix, segmentWildcard) {
+		return errors.New("wildcards are not allowed")
+	}
+	_, err := compileRegistryPattern(prefix)
+	return err
+}
+
+// mirrorImage returns the image rewritten to its mirror, if a mirror rule
+// matches the normalized image name on whole path segments. The tag and
+// digest of the image are kept as written. Invalid references and blocked
+// images are left untouched, for the validation to report them: blocked
+// entries are written against the name the pod references, and must win
+// over the trust of the mirror.
+func (s *Settings) mirrorImage(image string) (string, bool) {
+	raw, err := parseImageReference(image)
+	if err != nil {
+		return "", false
+	}
+	ref := raw.Normalize()
+	if _, blocked := findMatchingPattern(ref, s.blockedPatterns); blocked {
+		return "", false
+	}
+	name := ref.Name()
+	for _, rule := range s.mirrors {
+		if !hasNamePrefix(name, rule.source) {
+			continue
+		}
+		mirrored := imageReference{Tag: raw.Tag, Digest: raw.Digest}
+		mirrored.Registry, mirrored.Repository = splitDomain(rule.mirror + strings.TrimPrefix(name, rule.source))
+		return mirrored.String(), true
+	}
+	return "", false
+}
+
+// mirrorPodSpec rewrites the images of every container of the pod spec to
+// their mirror and reports whether any image changed.
+func (s *Settings) mirrorPodSpec(podSpec *corev1.PodSpec) bool {
+	mutated := false
+	for _, c := range podSpecImages(podSpec) {
+		if mirrored, found := s.mirrorImage(*c.image); found {
+			logger.Info(fmt.Sprintf("Rewriting image %s of %s to %s", *c.image, c.container, mirrored))
+			*c.image = mirrored
+			mutated = true
+		}
+	}
+	return mutated
+}
+
+// containerImage is the image field of a container of a decoded pod spec,
+// which the mutating mode rewrites in place.
+type containerImage struct {
+	container container
+	image     *string
+}
+
+// podSpecImages returns the images of the containers, init containers and
+// ephemeral containers of the pod spec, in that order.
+func podSpecImages(podSpec *corev1.PodSpec) []containerImage {
+	var images []containerImage
+	add := func(name *string, containerType containerType, image *string) {
+		c := container{Type: containerType}
+		if name != nil {
+			c.Name = *name
+		}
+		images = append(images, containerImage{container: c, image: image})
+	}
+	for _, c := range podSpec.Containers {
+		add(c.Name, containerTypeContainer, &c.Image)
+	}
+	for _, c := range podSpec.InitContainers {
+		add(c.Name, containerTypeInit, &c.Image)
+	}
+	for _, c := range podSpec.EphemeralContainers {
+		add(c.Name, containerTypeEphemeral, &c.Image)
+	}
+	return images
+}
+
+// hasNamePrefix reports whether the image name equals the prefix or lives
+// below it, comparing whole path segments.
+func hasNamePrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// canMutate reports whether the pod spec of objects of the kind can be
+// patched for the operation. The legacy EphemeralContainers kind cannot be
+// patched, and the API server forbids adding pull secrets to running pods,
+// where rewriting images would also restart their containers, so the
+// images of these requests are only validated.
+func canMutate(kind, operation string) bool {
+	if kind == "EphemeralContainers" {
+		return false
+	}
+	return operation != "UPDATE" || (kind != "" && kind != "Pod")
+}
+
+// mutate rewrites the images of the pod spec to their mirrors, pins them to
+// their digest, adds the pull secrets of their registries, validates the
+// rewritten images and returns the patched object. Requests that need no
+// patching are validated as they are.
+func mutate(payload []byte, kind string, podSpec gjson.Result, settings *Settings, ctx requestContext) ([]byte, error) {
+	spec := corev1.PodSpec{}
+	if err := json.Unmarshal([]byte(podSpec.Raw), &spec); err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("cannot decode pod spec: %v", err)),
+			kubewarden.Code(httpBadRequestStatusCode))
+	}
+	// Digests are resolved and pull secrets are added once the images are
+	// rewritten, so that they match the registries actually pulled from.
+	mirrored := settings.mirrorPodSpec(&spec)
+	pinned := false
+	var violations []violation
+	if settings.PinDigests {
+		pinned, violations = settings.pinPodSpec(&spec, newDigestResolver(&host), ctx)
+	}
+	injected := settings.injectPullSecrets(&spec)
+	if !mirrored && !pinned && !injected && len(violations) == 0 {
+		return validatePodSpec(podSpec, settings, ctx)
+	}
+
+	mutated, err := json.Marshal(spec)
+	if err != nil {
+		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.NoCode)
+	}
+	mutatedSpec := gjson.ParseBytes(mutated)
+	ctx.PullSecrets = podSpecPullSecrets(mutatedSpec)
+	violations = append(violations, validateContainers(getContainers(mutatedSpec), settings, ctx)...)
+	if len(violations) > 0 {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(formatViolations(violations)),
+			kubewarden.NoCode)
+	}
+
+	validationRequest := kubewarden_protocol.ValidationRequest{}
+	if err = json.Unmarshal(payload, &validationRequest); err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("cannot decode validation request: %v", err)),
+			kubewarden.Code(httpBadRequestStatusCode))
+	}
+	if kind == "" {
+		validationRequest.Request.Kind.Kind = "Pod"
+	}
+	return kubewarden.MutatePodSpecFromRequest(validationRequest, spec)
+}
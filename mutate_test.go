@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/tidwall/gjson"
+)
+
+func TestMirrorImage(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("mirror.corp"),
+		Mutate:            true,
+		Mirrors: map[string]string{
+			"docker.io":         "mirror.corp/dockerhub",
+			"docker.io/bitnami": "mirror.corp/bitnami",
+			"ghcr.io/":          "mirror.corp/ghcr/",
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		image    string
+		expected string
+	}{
+		{"docker.io/library/nginx:1.25", "mirror.corp/dockerhub/library/nginx:1.25"},
+		{"nginx", "mirror.corp/dockerhub/library/nginx"},
+		{"index.docker.io/someuser/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"mirror.corp/dockerhub/someuser/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"bitnami/redis:7.2", "mirror.corp/bitnami/redis:7.2"},
+		{"ghcr.io/org/tool:v1", "mirror.corp/ghcr/org/tool:v1"},
+		{"ghcr.io.evil.com/org/tool:v1", ""},
+		{"quay.io/org/app:1.0", ""},
+		{"mirror.corp/dockerhub/library/nginx:1.25", ""},
+		{"Invalid:Image", ""},
+	}
+
+	for _, testCase := range cases {
+		mirrored, found := settings.mirrorImage(testCase.image)
+		if mirrored != testCase.expected || found != (testCase.expected != "") {
+			t.Errorf("Image %s: expected %q, got %q (%v)", testCase.image, testCase.expected, mirrored, found)
+		}
+		// Rewriting is idempotent, since the pods of a rewritten workload
+		// are evaluated again.
+		if found {
+			if again, rewritten := settings.mirrorImage(mirrored); rewritten {
+				t.Errorf("Image %s: mirrored image %s was rewritten again to %s", testCase.image, mirrored, again)
+			}
+		}
+	}
+}
+
+func TestCompileMirrorsErrors(t *testing.T) {
+	cases := []map[string]string{
+		{"docker.io/*": "mirror.corp/dockerhub"},
+		{"docker.io": "mirror.corp/**"},
+		{"docker.io": "dockerhub"},
+		{"docker.io": ""},
+		{"": "mirror.corp"},
+		{"Docker.io/Library": "mirror.corp"},
+		// Mirrors under a source would be rewritten again
+		{"registry.corp": "registry.corp/cache"},
+		{"registry.corp/app": "registry.corp/app"},
+		{"index.docker.io": "docker.io/mirror"},
+		{"docker.io": "registry.corp/dockerhub", "registry.corp": "mirror.corp/corp"},
+	}
+
+	for _, mirrors := range cases {
+		if _, err := compileMirrors(mirrors); err == nil {
+			t.Errorf("Expected an error compiling mirrors %v", mirrors)
+		}
+	}
+}
+
+func TestValidateMutatesImagesToMirror(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "mirror.corp"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"docker.io": "mirror.corp/dockerhub", "gcr.io": "mirror.corp/gcr"},
+	}
+
+	cases := []struct {
+		fixture        string
+		imagePath      string
+		expectedImage  string
+		expectedKind   string
+		untouchedPath  string
+		untouchedImage string
+	}{
+		{
+			fixture:       "test_data/pod-untrusted.json",
+			imagePath:     "spec.containers.0.image",
+			expectedImage: "mirror.corp/dockerhub/library/nginx:latest",
+			expectedKind:  "Pod",
+		},
+		{
+			fixture:        "test_data/deployment.json",
+			imagePath:      "spec.template.spec.initContainers.0.image",
+			expectedImage:  "mirror.corp/gcr/some/init:1.0",
+			expectedKind:   "Deployment",
+			untouchedPath:  "spec.template.spec.containers.0.image",
+			untouchedImage: "quay.io/some/app:1.0",
+		},
+		{
+			fixture:        "test_data/cronjob.json",
+			imagePath:      "spec.jobTemplate.spec.template.spec.initContainers.0.image",
+			expectedImage:  "mirror.corp/gcr/some/init:1.0",
+			expectedKind:   "CronJob",
+			untouchedPath:  "spec.jobTemplate.spec.template.spec.containers.0.image",
+			untouchedImage: "quay.io/some/app:1.0",
+		},
+	}
+
+	for _, testCase := range cases {
+		response := validateFixture(t, testCase.fixture, &settings)
+		if !response.Accepted {
+			t.Errorf("%s: unexpected rejection: %v", testCase.fixture, *response.Message)
+			continue
+		}
+		if response.MutatedObject == nil {
+			t.Errorf("%s: expected a mutated object", testCase.fixture)
+			continue
+		}
+		mutatedObject, err := json.Marshal(response.MutatedObject)
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		object := gjson.ParseBytes(mutatedObject)
+		if kind := object.Get("kind").String(); kind != testCase.expectedKind {
+			t.Errorf("%s: expected a %s, got %q", testCase.fixture, testCase.expectedKind, kind)
+		}
+		if image := object.Get(testCase.imagePath).String(); image != testCase.expectedImage {
+			t.Errorf("%s: expected image %q, got %q", testCase.fixture, testCase.expectedImage, image)
+		}
+		if testCase.untouchedPath != "" {
+			if image := object.Get(testCase.untouchedPath).String(); image != testCase.untouchedImage {
+				t.Errorf("%s: expected image %q, got %q", testCase.fixture, testCase.untouchedImage, image)
+			}
+		}
+	}
+}
+
+func TestValidateDoesNotMutateWithoutMirrorMatch(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "mirror.corp"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"docker.io": "mirror.corp/dockerhub"},
+	}
+
+	response := validateFixture(t, "test_data/pod-trusted.json", &settings)
+	if !response.Accepted {
+		t.Fatalf("Unexpected rejection: %v", *response.Message)
+	}
+	if response.MutatedObject != nil {
+		t.Errorf("Unexpected mutated object: %v", response.MutatedObject)
+	}
+}
+
+func TestValidateRejectsUntrustedMirror(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"docker.io": "mirror.corp/dockerhub"},
+	}
+
+	response := validateFixture(t, "test_data/pod-untrusted.json", &settings)
+	if response.Accepted {
+		t.Fatalf("Unexpected acceptance")
+	}
+	expected := "container 'nginx': image 'mirror.corp/dockerhub/library/nginx:latest' is not from a trusted registry"
+	if *response.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, *response.Message)
+	}
+}
+
+func TestValidateDoesNotMirrorBlockedImages(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("mirror.corp/dockerhub"),
+		Blocked:           mapset.NewThreadUnsafeSet[string]("docker.io/someuser"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"docker.io": "mirror.corp/dockerhub"},
+	}
+
+	response := validatePodImages(t, []string{"docker.io/someuser/evil:1.0"}, &settings)
+	if response.Accepted || response.MutatedObject != nil {
+		t.Fatalf("Expected a rejection without mutation, got %+v", response)
+	}
+	expected := "container 'container-0': image 'docker.io/someuser/evil:1.0' is blocked by 'docker.io/someuser'"
+	if *response.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, *response.Message)
+	}
+
+	response = validatePodImages(t, []string{"docker.io/otheruser/app:1.0"}, &settings)
+	if !response.Accepted || response.MutatedObject == nil {
+		t.Errorf("Expected images that are not blocked to be mirrored, got %+v", response)
+	}
+}
+
+func TestValidateDoesNotMutateWhenDisabled(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "mirror.corp"),
+		Mirrors:           map[string]string{"docker.io": "mirror.corp/dockerhub"},
+	}
+
+	response := validateFixture(t, "test_data/pod-untrusted.json", &settings)
+	if response.Accepted || response.MutatedObject != nil {
+		t.Errorf("Expected a rejection without mutation, got %+v", response)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+	"github.com/tidwall/gjson"
+)
+
+// fetchNamespaceLabels returns the labels of the namespace, fetched through
+// the Kubernetes capability of the host.
+func fetchNamespaceLabels(h *capabilities.Host, name string) (map[string]string, error) {
+	if h.Client == nil {
+		return nil, errors.New("host capabilities are not available")
+	}
+	namespace, err := kubernetes.GetResource(h, kubernetes.GetResourceRequest{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(namespace) {
+		return nil, errors.New("host returned an invalid namespace")
+	}
+	return stringMap(gjson.GetBytes(namespace, "metadata.labels")), nil
+}
+
+// selectsNamespaceLabels reports whether any namespace rule selects
+// namespaces by label.
+func (s *Settings) selectsNamespaceLabels() bool {
+	for i := range s.NamespaceRules {
+		if len(s.NamespaceRules[i].NamespaceLabels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// needsNamespaceLabels reports whether a namespace rule selecting by label
+// is reached for the namespace before a rule selecting it by name only.
+func (s *Settings) needsNamespaceLabels(namespace string) bool {
+	for i := range s.NamespaceRules {
+		if s.NamespaceRules[i].selectsName(namespace) {
+			return len(s.NamespaceRules[i].NamespaceLabels) > 0
+		}
+	}
+	return false
+}
+
+// loadNamespaceLabels fetches the labels of the namespace of the request
+// when namespace rules need them. When the lookup fails, the namespace
+// label fallback applies; without a fallback the request cannot be
+// evaluated and an error is returned.
+func (s *Settings) loadNamespaceLabels(ctx *requestContext) error {
+	if ctx.Namespace == "" || !s.needsNamespaceLabels(ctx.Namespace) {
+		return nil
+	}
+	labels, err := fetchNamespaceLabels(&host, ctx.Namespace)
+	if err == nil {
+		ctx.NamespaceLabels = labels
+		return nil
+	}
+	if s.namespaceFallback.rules.empty() {
+		return fmt.Errorf("cannot look up the labels of namespace '%s': %w", ctx.Namespace, err)
+	}
+	logger.Warn(fmt.Sprintf("Cannot look up the labels of namespace %s, using the namespace label fallback: %v", ctx.Namespace, err))
+	ctx.namespaceLabelsUnavailable = true
+	return nil
+}
+
+// validateNamespaceLabels checks the keys of a namespace label selector.
+// Values may be empty, as label values can be.
+func validateNamespaceLabels(labels map[string]string) error {
+	for key := range labels {
+		if key == "" {
+			return errors.New("namespace label keys must not be empty")
+		}
+	}
+	return nil
+}
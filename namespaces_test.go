@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+)
+
+func namespaceWithLabels(labels map[string]string) map[string]any {
+	return map[string]any{"metadata": map[string]any{"labels": labels}}
+}
+
+func TestValidateNamespaceLabelRules(t *testing.T) {
+	client := newFakeHost()
+	client.resources["Namespace/payments"] = namespaceWithLabels(map[string]string{"security-tier": "restricted"})
+	client.resources["Namespace/web"] = namespaceWithLabels(map[string]string{"security-tier": "standard"})
+	client.resources["Namespace/sandbox"] = namespaceWithLabels(map[string]string{"security-tier": "lab", "team": "data"})
+	client.resources["Namespace/legacy"] = namespaceWithLabels(nil)
+	client.errs["Namespace/down"] = errors.New("api server unreachable")
+
+	cases := []struct {
+		namespace       string
+		fallback        []string
+		image           string
+		expectedMessage string
+	}{
+		{namespace: "payments", image: "registry.corp/restricted/app:1.0"},
+		{
+			namespace:       "payments",
+			image:           "registry.corp/app:1.0",
+			expectedMessage: "container 'container-0': image 'registry.corp/app:1.0' is not from a registry trusted in namespace 'payments'",
+		},
+		{namespace: "web", image: "registry.corp/app:1.0"},
+		{namespace: "sandbox", image: "docker.io/library/busybox:1.36"},
+		{
+			namespace:       "legacy",
+			image:           "registry.corp/app:1.0",
+			expectedMessage: "container 'container-0': image 'registry.corp/app:1.0' is not from a trusted registry",
+		},
+		{namespace: "kube-system", image: "registry.k8s.io/pause:3.9"},
+		{namespace: "down", fallback: []string{"registry.corp/restricted"}, image: "registry.corp/restricted/app:1.0"},
+		{
+			namespace:       "down",
+			fallback:        []string{"registry.corp/restricted"},
+			image:           "registry.corp/app:1.0",
+			expectedMessage: "container 'container-0': image 'registry.corp/app:1.0' is not from a registry trusted in namespace 'down'",
+		},
+		{
+			namespace:       "down",
+			image:           "registry.corp/restricted/app:1.0",
+			expectedMessage: "cannot look up the labels of namespace 'down': api server unreachable",
+		},
+	}
+
+	for _, testCase := range cases {
+		useFakeHost(t, client)
+		settings := Settings{
+			NamespaceRules: []NamespaceRule{
+				{Namespaces: []string{"kube-system"}, TrustedRegistries: []string{"registry.k8s.io"}},
+				{NamespaceLabels: map[string]string{"security-tier": "restricted"}, TrustedRegistries: []string{"registry.corp/restricted"}},
+				{NamespaceLabels: map[string]string{"security-tier": "standard"}, TrustedRegistries: []string{"registry.corp"}},
+				{NamespaceLabels: map[string]string{"security-tier": "lab"}, TrustedRegistries: []string{"registry.corp", "docker.io"}},
+			},
+			NamespaceLabelsFallback: testCase.fallback,
+		}
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{testCase.image}, &settings)
+		if response.Accepted != (testCase.expectedMessage == "") {
+			t.Errorf("Namespace %s, image %s: unexpected accepted=%v (%v)", testCase.namespace, testCase.image, response.Accepted, response.Message)
+			continue
+		}
+		if !response.Accepted && *response.Message != testCase.expectedMessage {
+			t.Errorf("Expected message %q, got %q", testCase.expectedMessage, *response.Message)
+		}
+	}
+}
+
+func TestValidateDoesNotLookUpNamespacesWithoutLabelRules(t *testing.T) {
+	client := newFakeHost()
+	useFakeHost(t, client)
+	settings := Settings{
+		NamespaceRules: []NamespaceRule{{Namespaces: []string{"default"}, TrustedRegistries: []string{"registry.corp"}}},
+	}
+	if err := settings.compile(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	response := validatePodImages(t, []string{"registry.corp/app:1.0"}, &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %v", *response.Message)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("Expected no host call, got %v", client.calls)
+	}
+}
+
+func TestFetchNamespaceLabels(t *testing.T) {
+	client := newFakeHost()
+	client.resources["Namespace/web"] = namespaceWithLabels(map[string]string{"security-tier": "standard"})
+
+	labels, err := fetchNamespaceLabels(&capabilities.Host{Client: client}, "web")
+	if err != nil || labels["security-tier"] != "standard" {
+		t.Errorf("Unexpected labels %v (%v)", labels, err)
+	}
+	if _, err = fetchNamespaceLabels(&capabilities.Host{}, "web"); err == nil {
+		t.Errorf("Expected an error without host client")
+	}
+}
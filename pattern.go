@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	segmentWildcard   = "*"
+	recursiveWildcard = "**"
+)
+
+// registryPattern is a compiled trusted or blocked registry entry. An entry
+// matches the image names it equals and every name below it, comparing
+// whole host and path segments, so "quay.io" matches "quay.io/org/app" but
+// neither "quay.io.evil.com/app" nor "quay.ioevil/app".
+//
+// Entries may use globs: `*` matches any characters within a single segment
+// and `**` matches any number of path segments.
+type registryPattern struct {
+	entry    string
+	segments []string
+}
+
+func (p registryPattern) String() string {
+	return p.entry
+}
+
+// compileRegistryPattern validates the entry and prepares it for matching.
+func compileRegistryPattern(entry string) (registryPattern, error) {
+	normalized := normalizeRegistryEntry(entry)
+	if normalized == "" {
+		return registryPattern{}, errors.New("entry is empty")
+	}
+
+	segments := strings.Split(normalized, "/")
+	host := segments[0]
+	if strings.Trim(host, segmentWildcard) == "" {
+		return registryPattern{}, errors.New("the registry host must not be a bare wildcard")
+	}
+	if err := validateHostPattern(host); err != nil {
+		return registryPattern{}, err
+	}
+	for _, segment := range segments[1:] {
+		if err := validatePathPattern(segment); err != nil {
+			return registryPattern{}, err
+		}
+	}
+
+	return registryPattern{entry: entry, segments: segments}, nil
+}
+
+// compileRegistryPatterns compiles every entry, sorted so that evaluation
+// and error reporting do not depend on the iteration order of the sets the
+// entries come from.
+func compileRegistryPatterns(entries []string) ([]registryPattern, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]string(nil), entries...)
+	sort.Strings(sorted)
+
+	patterns := make([]registryPattern, 0, len(sorted))
+	for _, entry := range sorted {
+		pattern, err := compileRegistryPattern(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry '%s': %w", entry, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Match reports whether the image name is matched by the pattern or lives
+// below a name matched by it.
+func (p registryPattern) Match(name string) bool {
+	return matchSegmentsPrefix(p.segments, strings.Split(name, "/"))
+}
+
+func matchSegmentsPrefix(patternSegments, nameSegments []string) bool {
+	if len(patternSegments) == 0 {
+		return true
+	}
+
+	if patternSegments[0] == recursiveWildcard {
+		for i := 0; i <= len(nameSegments); i++ {
+			if matchSegmentsPrefix(patternSegments[1:], nameSegments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(nameSegments) == 0 || !matchSegment(patternSegments[0], nameSegments[0]) {
+		return false
+	}
+	return matchSegmentsPrefix(patternSegments[1:], nameSegments[1:])
+}
+
+// matchSegment matches a single segment against a pattern where `*` stands
+// for any sequence of characters.
+func matchSegment(pattern, segment string) bool {
+	parts := strings.Split(pattern, segmentWildcard)
+	if len(parts) == 1 {
+		return pattern == segment
+	}
+
+	if !strings.HasPrefix(segment, parts[0]) {
+		return false
+	}
+	segment = segment[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(segment, part)
+		if idx < 0 {
+			return false
+		}
+		segment = segment[idx+len(part):]
+	}
+	return len(segment) >= len(last) && strings.HasSuffix(segment, last)
+}
+
+func validateHostPattern(host string) error {
+	if strings.Contains(host, recursiveWildcard) {
+		return fmt.Errorf("'%s' is only allowed as a whole path segment", recursiveWildcard)
+	}
+	if !strings.Contains(host, segmentWildcard) {
+		return validateDomain(host)
+	}
+	for i := range len(host) {
+		c := host[i]
+		if !isAlphaNumeric(c) && !strings.ContainsRune(".-:[]*", rune(c)) {
+			return fmt.Errorf("invalid character %q in registry host pattern '%s'", c, host)
+		}
+	}
+	return nil
+}
+
+func validatePathPattern(segment string) error {
+	if segment == "" {
+		return errors.New("path segments must not be empty")
+	}
+	if segment == recursiveWildcard {
+		return nil
+	}
+	if strings.Contains(segment, recursiveWildcard) {
+		return fmt.Errorf("'%s' is only allowed as a whole path segment", recursiveWildcard)
+	}
+	if !strings.Contains(segment, segmentWildcard) {
+		if !isPathComponent(segment) {
+			return fmt.Errorf("invalid path segment '%s': must be lowercase alphanumerics separated by '.', '_', '__' or '-'", segment)
+		}
+		return nil
+	}
+	for i := range len(segment) {
+		c := segment[i]
+		if !isLowerAlphaNumeric(c) && !strings.ContainsRune("._-*", rune(c)) {
+			return fmt.Errorf("invalid character %q in path segment pattern '%s'", c, segment)
+		}
+	}
+	return nil
+}
+
+// compileTrustedRegexps compiles the regular expressions of the
+// trusted_patterns setting. Patterns must start with "^" and match the whole
+// image name, like allowed tag patterns, so that a pattern meant for one
+// registry cannot match an attacker controlled host that merely contains
+// its name, such as "registry.corp.attacker.io". Both anchors are applied to
+// the pattern as a whole, since "^" and "$" only bind to the first and last
+// branches of a top level alternation such as "^registry\.corp/.*|evil". Go
+// regular expressions follow the RE2 syntax, which is also what TinyGo
+// provides: lookarounds and backreferences are not available.
+func compileTrustedRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]string(nil), patterns...)
+	sort.Strings(sorted)
+
+	compiled := make([]*regexp.Regexp, 0, len(sorted))
+	for _, pattern := range sorted {
+		if !strings.HasPrefix(pattern, "^") {
+			return nil, fmt.Errorf("invalid pattern '%s': must be anchored with '^'", pattern)
+		}
+		// The pattern is compiled on its own first, so that unbalanced
+		// parentheses cannot close the anchoring group.
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w (only RE2 syntax is supported, without lookarounds or backreferences)", pattern, err)
+		}
+		re, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, "^") + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// trustRules are the compiled trusted registries and patterns that apply to
+// a request.
+type trustRules struct {
+	registries []registryPattern
+	templates  []registryTemplate
+	regexps    []*regexp.Regexp
+}
+
+func compileTrustRules(registries, patterns []string) (trustRules, error) {
+	var (
+		rules   trustRules
+		entries []string
+		err     error
+	)
+	for _, entry := range registries {
+		if !isRegistryTemplate(entry) {
+			entries = append(entries, entry)
+			continue
+		}
+		template, templateErr := compileRegistryTemplate(entry)
+		if templateErr != nil {
+			return trustRules{}, fmt.Errorf("trusted_registries: invalid entry '%s': %w", entry, templateErr)
+		}
+		rules.templates = append(rules.templates, template)
+	}
+	rules.registries, err = compileRegistryPatterns(entries)
+	if err != nil {
+		return trustRules{}, fmt.Errorf("trusted_registries: %w", err)
+	}
+	rules.regexps, err = compileTrustedRegexps(patterns)
+	if err != nil {
+		return trustRules{}, fmt.Errorf("trusted_patterns: %w", err)
+	}
+	return rules, nil
+}
+
+func (r trustRules) empty() bool {
+	return len(r.registries) == 0 && len(r.templates) == 0 && len(r.regexps) == 0
+}
+
+// resolve returns the rules with every template replaced by the registry
+// pattern it resolves to for the request.
+func (r trustRules) resolve(ctx requestContext) trustRules {
+	if len(r.templates) == 0 {
+		return r
+	}
+
+	resolved := trustRules{
+		registries: append([]registryPattern(nil), r.registries...),
+		regexps:    r.regexps,
+	}
+	for _, template := range r.templates {
+		pattern, err := template.resolve(ctx)
+		if err != nil {
+			logger.Debug(fmt.Sprintf("Trusted registry %s does not apply to the request: %v", template.entry, err))
+			continue
+		}
+		resolved.registries = append(resolved.registries, pattern)
+	}
+	return resolved
+}
+
+// trusts reports whether the image is matched by any trusted registry or
+// pattern.
+func (r trustRules) trusts(ref imageReference) bool {
+	if _, found := findMatchingPattern(ref, r.registries); found {
+		return true
+	}
+	name := ref.Name()
+	for _, re := range r.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMatchingPattern returns the first pattern matching the image name.
+func findMatchingPattern(ref imageReference, patterns []registryPattern) (registryPattern, bool) {
+	name := ref.Name()
+	for _, pattern := range patterns {
+		if pattern.Match(name) {
+			return pattern, true
+		}
+	}
+	return registryPattern{}, false
+}
+
+// matchNamespace reports whether the namespace is selected by an exact
+// namespace name or a glob using `*`.
+func matchNamespace(selector, namespace string) bool {
+	return matchSegment(selector, namespace)
+}
+
+func validateNamespaceSelector(selector string) error {
+	if selector == "" {
+		return errors.New("namespace selectors must not be empty")
+	}
+	for i := range len(selector) {
+		c := selector[i]
+		if !isLowerAlphaNumeric(c) && c != '-' && c != '*' {
+			return fmt.Errorf("invalid namespace selector '%s': only lowercase alphanumerics, '-' and '*' are allowed", selector)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRegistryPatternMatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		entry    string
+		expected bool
+	}{
+		{"quay.io/some/image", "quay.io", true},
+		{"quay.io/some/image", "quay.io/", true},
+		{"quay.io/some/image", "quay.io/some", true},
+		{"quay.io/some/image", "quay.io/some/image", true},
+		{"quay.io.attacker.com/evil", "quay.io", false},
+		{"quay.ioevil/x", "quay.io", false},
+		{"quay.io/someother/image", "quay.io/some", false},
+		{"localhost:50001/app", "localhost:5000", false},
+		{"docker.io/library/nginx", "index.docker.io", true},
+		{"docker.io/library/nginx", "index.docker.io/library", true},
+		// `*` matches within a single segment
+		{"eu-1.registry.corp/app", "*.registry.corp", true},
+		{"us-2.registry.corp/team/app", "*.registry.corp", true},
+		{"registry.corp/app", "*.registry.corp", false},
+		{"eu-1.registry.corp.evil.com/app", "*.registry.corp", false},
+		{"eu-1.registry.corp/app", "eu-*.registry.corp", true},
+		{"us-1.registry.corp/app", "eu-*.registry.corp", false},
+		{"registry.corp/team-a/app", "registry.corp/team-*/", true},
+		{"registry.corp/team-a/sub/app", "registry.corp/team-*", true},
+		{"registry.corp/teams/app", "registry.corp/team-*", false},
+		{"registry.corp/team-a/app", "registry.corp/*/app", true},
+		{"registry.corp/team-a/sub/app", "registry.corp/*/app", false},
+		{"registry.corp/a-b-c/app", "registry.corp/a-*-c", true},
+		{"registry.corp/a-c/app", "registry.corp/a-*-c", false},
+		// `**` matches across path segments
+		{"registry.corp/team-a/sub/app", "registry.corp/**/app", true},
+		{"registry.corp/app", "registry.corp/**/app", true},
+		{"registry.corp/team-a/sub/other", "registry.corp/**/app", false},
+		{"registry.corp/a/b/c/stable/app", "registry.corp/**/stable", true},
+	}
+
+	for _, testCase := range cases {
+		pattern, err := compileRegistryPattern(testCase.entry)
+		if err != nil {
+			t.Errorf("Unexpected error compiling %q: %+v", testCase.entry, err)
+			continue
+		}
+		if got := pattern.Match(testCase.name); got != testCase.expected {
+			t.Errorf("Pattern %q matching %q: expected %v, got %v", testCase.entry, testCase.name, testCase.expected, got)
+		}
+	}
+}
+
+func TestCompileRegistryPatternErrors(t *testing.T) {
+	entries := []string{
+		"",
+		"/",
+		"*",
+		"**",
+		"*/library",
+		"**.corp",
+		"registry.corp/team-**",
+		"registry.corp//app",
+		"registry.corp/Team",
+		"registry.corp/team-[ab]",
+		"registry.corp/team?",
+		"-registry.corp",
+		"registry.corp:port",
+		"reg_istry.*",
+	}
+
+	for _, entry := range entries {
+		if _, err := compileRegistryPattern(entry); err == nil {
+			t.Errorf("Expected an error compiling %q", entry)
+		}
+	}
+}
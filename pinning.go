@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/oci/manifest_digest"
+)
+
+// host gives access to the capabilities of the Kubewarden host.
+//
+//nolint:gochecknoglobals // Tests replace its client to mock host callbacks.
+var host = capabilities.NewHost()
+
+// digestResolver resolves the digest of images through the OCI manifest
+// digest capability of the host. Results, including failures, are cached
+// for the duration of a request, so that an image used by several
+// containers is resolved once.
+type digestResolver struct {
+	host  *capabilities.Host
+	cache map[string]digestResolution
+}
+
+type digestResolution struct {
+	digest string
+	err    error
+}
+
+func newDigestResolver(h *capabilities.Host) *digestResolver {
+	return &digestResolver{host: h, cache: map[string]digestResolution{}}
+}
+
+// resolve returns the digest the image currently points to.
+func (r *digestResolver) resolve(image string) (string, error) {
+	if cached, found := r.cache[image]; found {
+		return cached.digest, cached.err
+	}
+	digest, err := r.lookup(image)
+	r.cache[image] = digestResolution{digest: digest, err: err}
+	return digest, err
+}
+
+func (r *digestResolver) lookup(image string) (string, error) {
+	if r.host.Client == nil {
+		return "", errors.New("host capabilities are not available")
+	}
+	digest, err := manifest_digest.GetOCIManifestDigest(r.host, image)
+	if err != nil {
+		return "", err
+	}
+	if err = validateDigest(digest); err != nil {
+		return "", fmt.Errorf("host returned an %w", err)
+	}
+	return digest, nil
+}
+
+// pinPodSpec appends to every image without a digest the digest its tag
+// currently points to, so that the running pod cannot drift. Only images
+// from trusted registries that are not blocked are resolved, so the host
+// never contacts registries the policy rejects anyway. Images that cannot be
+// resolved are left as they are when pinning fails open, and are reported
+// as violations otherwise.
+func (s *Settings) pinPodSpec(podSpec *corev1.PodSpec, resolver *digestResolver, ctx requestContext) (bool, []violation) {
+	rules, _ := s.trustRulesFor(ctx)
+	rules = rules.resolve(ctx)
+
+	pinned := false
+	var violations []violation
+	for _, c := range podSpecImages(podSpec) {
+		raw, err := parseImageReference(*c.image)
+		if err != nil || raw.Digest != "" {
+			continue
+		}
+		ref := raw.Normalize()
+		if _, blocked := findMatchingPattern(ref, s.blockedPatterns); blocked || !rules.trusts(ref) {
+			continue
+		}
+
+		digest, err := resolver.resolve(*c.image)
+		if err != nil {
+			if s.PinDigestsFailOpen {
+				logger.Warn(fmt.Sprintf("Cannot pin image %s of %s to a digest, leaving it unpinned: %v", *c.image, c.container, err))
+				continue
+			}
+			logger.Error(fmt.Sprintf("Cannot pin image %s of %s to a digest: %v", *c.image, c.container, err))
+			c.container.Image = *c.image
+			violations = append(violations, violation{
+				Container: c.container,
+				Reference: ref.String(),
+				Reason:    fmt.Sprintf("cannot be pinned to a digest: %v", err),
+			})
+			continue
+		}
+
+		raw.Digest = digest
+		logger.Info(fmt.Sprintf("Pinning image %s of %s to %s", *c.image, c.container, raw))
+		*c.image = raw.String()
+		pinned = true
+	}
+	return pinned, violations
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	appDigest  = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	initDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+)
+
+func TestDigestResolverCachesResults(t *testing.T) {
+	client := newFakeHost()
+	client.digests["registry.corp/app:1.4"] = appDigest
+	client.errs["registry.corp/down:1.0"] = errors.New("registry unreachable")
+	resolver := newDigestResolver(&capabilities.Host{Client: client})
+
+	for range 3 {
+		digest, err := resolver.resolve("registry.corp/app:1.4")
+		if err != nil || digest != appDigest {
+			t.Errorf("Expected digest %s, got %q (%v)", appDigest, digest, err)
+		}
+		if _, err = resolver.resolve("registry.corp/down:1.0"); err == nil {
+			t.Errorf("Expected an error resolving an unreachable registry")
+		}
+	}
+	if client.calls["registry.corp/app:1.4"] != 1 || client.calls["registry.corp/down:1.0"] != 1 {
+		t.Errorf("Expected a single host call per image, got %v", client.calls)
+	}
+}
+
+func TestDigestResolverRejectsInvalidDigests(t *testing.T) {
+	client := newFakeHost()
+	client.digests["registry.corp/app:1.4"] = "sha256:1234"
+	resolver := newDigestResolver(&capabilities.Host{Client: client})
+
+	if _, err := resolver.resolve("registry.corp/app:1.4"); err == nil {
+		t.Errorf("Expected an error for an invalid digest")
+	}
+	if _, err := newDigestResolver(&capabilities.Host{}).resolve("registry.corp/app:1.4"); err == nil {
+		t.Errorf("Expected an error without host client")
+	}
+}
+
+func TestValidatePinsImagesToDigests(t *testing.T) {
+	client := newFakeHost()
+	client.digests["quay.io/some/app:1.0"] = appDigest
+	client.digests["gcr.io/some/init:1.0"] = initDigest
+	useFakeHost(t, client)
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"),
+		Mutate:            true,
+		PinDigests:        true,
+	}
+
+	response := validateFixture(t, "test_data/deployment.json", &settings)
+	if !response.Accepted {
+		t.Fatalf("Unexpected rejection: %v", *response.Message)
+	}
+	mutatedObject, err := json.Marshal(response.MutatedObject)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	spec := gjson.GetBytes(mutatedObject, "spec.template.spec")
+	if image := spec.Get("containers.0.image").String(); image != "quay.io/some/app:1.0@"+appDigest {
+		t.Errorf("Unexpected container image %q", image)
+	}
+	if image := spec.Get("initContainers.0.image").String(); image != "gcr.io/some/init:1.0@"+initDigest {
+		t.Errorf("Unexpected init container image %q", image)
+	}
+}
+
+func TestValidatePinningFailurePolicy(t *testing.T) {
+	cases := []struct {
+		failOpen         bool
+		expectedAccepted bool
+		expectedMessage  string
+	}{
+		{
+			failOpen:        false,
+			expectedMessage: "container 'app': image 'quay.io/some/app:1.0' cannot be pinned to a digest: registry unreachable",
+		},
+		{failOpen: true, expectedAccepted: true},
+	}
+
+	for _, testCase := range cases {
+		client := newFakeHost()
+		client.digests["gcr.io/some/init:1.0"] = initDigest
+		client.errs["quay.io/some/app:1.0"] = errors.New("registry unreachable")
+		useFakeHost(t, client)
+		settings := Settings{
+			TrustedRegistries:  mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"),
+			Mutate:             true,
+			PinDigests:         true,
+			PinDigestsFailOpen: testCase.failOpen,
+		}
+
+		response := validateFixture(t, "test_data/deployment.json", &settings)
+		if response.Accepted != testCase.expectedAccepted {
+			t.Errorf("Fail open %v: expected accepted=%v, got %v", testCase.failOpen, testCase.expectedAccepted, response.Accepted)
+			continue
+		}
+		if !response.Accepted && *response.Message != testCase.expectedMessage {
+			t.Errorf("Expected message %q, got %q", testCase.expectedMessage, *response.Message)
+		}
+		if response.Accepted {
+			mutatedObject, err := json.Marshal(response.MutatedObject)
+			if err != nil {
+				t.Fatalf("Unexpected error: %+v", err)
+			}
+			spec := gjson.GetBytes(mutatedObject, "spec.template.spec")
+			if image := spec.Get("containers.0.image").String(); image != "quay.io/some/app:1.0" {
+				t.Errorf("Expected the unresolved image to be left unpinned, got %q", image)
+			}
+			if image := spec.Get("initContainers.0.image").String(); image != "gcr.io/some/init:1.0@"+initDigest {
+				t.Errorf("Unexpected init container image %q", image)
+			}
+		}
+	}
+}
+
+func TestValidateDoesNotResolveUntrustedOrPinnedImages(t *testing.T) {
+	client := newFakeHost()
+	useFakeHost(t, client)
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"),
+		Mutate:            true,
+		PinDigests:        true,
+	}
+
+	response := validatePodImages(t, []string{"quay.io/some/app@" + appDigest, "docker.io/attacker/app:1.0"}, &settings)
+	if response.Accepted {
+		t.Fatalf("Unexpected acceptance")
+	}
+	expected := "container 'container-1': image 'docker.io/attacker/app:1.0' is not from a trusted registry"
+	if *response.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, *response.Message)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("Expected no host call, got %v", client.calls)
+	}
+}
+
+func TestPinningSatisfiesRequireDigest(t *testing.T) {
+	client := newFakeHost()
+	client.digests["quay.io/some/app:1.0"] = appDigest
+	useFakeHost(t, client)
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		Mutate:            true,
+		PinDigests:        true,
+		RequireDigest:     true,
+	}
+
+	response := validatePodImages(t, []string{"quay.io/some/app:1.0"}, &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %v", *response.Message)
+	}
+}
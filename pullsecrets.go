@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+const maxSecretNameLength = 253
+
+// pullSecretRule names the pull secret needed by the images whose
+// normalized name starts with the registry prefix.
+type pullSecretRule struct {
+	registry string
+	secret   string
+}
+
+// compilePullSecrets validates the pull_secrets setting. Rules are sorted
+// from the longest registry prefix to the shortest, so that the most
+// specific prefix wins regardless of the order of the map.
+func compilePullSecrets(pullSecrets map[string]string) ([]pullSecretRule, error) {
+	rules := make([]pullSecretRule, 0, len(pullSecrets))
+	for registry, secret := range pullSecrets {
+		rule := pullSecretRule{registry: normalizeRegistryEntry(registry), secret: secret}
+		if err := validateRegistryPrefix(rule.registry); err != nil {
+			return nil, fmt.Errorf("invalid registry '%s': %w", registry, err)
+		}
+		if !isDNSSubdomain(secret) {
+			return nil, fmt.Errorf("invalid secret name '%s' for registry '%s': must be a lowercase DNS subdomain", secret, registry)
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].registry) != len(rules[j].registry) {
+			return len(rules[i].registry) > len(rules[j].registry)
+		}
+		return rules[i].registry < rules[j].registry
+	})
+	return rules, nil
+}
+
+// pullSecretFor returns the pull secret of the most specific registry prefix
+// matching the image.
+func (s *Settings) pullSecretFor(image string) (string, bool) {
+	ref, err := parseNormalizedImageReference(image)
+	if err != nil {
+		return "", false
+	}
+	name := ref.Name()
+	for _, rule := range s.pullSecrets {
+		if hasNamePrefix(name, rule.registry) {
+			return rule.secret, true
+		}
+	}
+	return "", false
+}
+
+// injectPullSecrets appends to the pod spec the pull secrets of the
+// registries its images come from, skipping the secrets it already lists,
+// and reports whether any secret was added.
+func (s *Settings) injectPullSecrets(podSpec *corev1.PodSpec) bool {
+	present := map[string]bool{}
+	for _, secret := range podSpec.ImagePullSecrets {
+		if secret != nil {
+			present[secret.Name] = true
+		}
+	}
+
+	injected := false
+	for _, c := range podSpecImages(podSpec) {
+		secret, found := s.pullSecretFor(*c.image)
+		if !found || present[secret] {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Adding pull secret %s for image %s of %s", secret, *c.image, c.container))
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, &corev1.LocalObjectReference{Name: secret})
+		present[secret] = true
+		injected = true
+	}
+	return injected
+}
+
+// isDNSSubdomain reports whether the name is a valid Kubernetes object name:
+// lowercase alphanumerics, '-' and '.', starting and ending with an
+// alphanumeric character.
+func isDNSSubdomain(name string) bool {
+	if name == "" || len(name) > maxSecretNameLength ||
+		!isLowerAlphaNumeric(name[0]) || !isLowerAlphaNumeric(name[len(name)-1]) {
+		return false
+	}
+	for i := range len(name) {
+		if !isLowerAlphaNumeric(name[i]) && name[i] != '-' && name[i] != '.' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	"github.com/tidwall/gjson"
+)
+
+func TestInjectPullSecrets(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp", "quay.io"),
+		Mutate:            true,
+		PullSecrets: map[string]string{
+			"registry.corp":  "corp-pull",
+			"quay.io/ourorg": "quay-pull",
+			"quay.io":        "quay-public",
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		name             string
+		images           []string
+		existingSecrets  []string
+		expectedSecrets  []string
+		expectedInjected bool
+	}{
+		{
+			name:             "one secret per referenced registry",
+			images:           []string{"registry.corp/app:1.0", "quay.io/ourorg/sidecar:2.0", "registry.corp/proxy:1.0"},
+			expectedSecrets:  []string{"corp-pull", "quay-pull"},
+			expectedInjected: true,
+		},
+		{
+			name:             "most specific prefix wins",
+			images:           []string{"quay.io/other/app:1.0"},
+			expectedSecrets:  []string{"quay-public"},
+			expectedInjected: true,
+		},
+		{
+			name:             "existing secrets are not duplicated",
+			images:           []string{"registry.corp/app:1.0", "quay.io/ourorg/sidecar:2.0"},
+			existingSecrets:  []string{"quay-pull"},
+			expectedSecrets:  []string{"quay-pull", "corp-pull"},
+			expectedInjected: true,
+		},
+		{
+			name:             "nothing to add",
+			images:           []string{"registry.corp/app:1.0"},
+			existingSecrets:  []string{"corp-pull"},
+			expectedSecrets:  []string{"corp-pull"},
+			expectedInjected: false,
+		},
+		{
+			name:             "no registry with a pull secret",
+			images:           []string{"registry.corp.evil.com/app:1.0"},
+			expectedInjected: false,
+		},
+	}
+
+	for _, testCase := range cases {
+		podSpec := corev1.PodSpec{}
+		for _, image := range testCase.images {
+			podSpec.Containers = append(podSpec.Containers, &corev1.Container{Image: image})
+		}
+		for _, secret := range testCase.existingSecrets {
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, &corev1.LocalObjectReference{Name: secret})
+		}
+
+		injected := settings.injectPullSecrets(&podSpec)
+		if injected != testCase.expectedInjected {
+			t.Errorf("%s: expected injected=%v, got %v", testCase.name, testCase.expectedInjected, injected)
+		}
+		var secrets []string
+		for _, secret := range podSpec.ImagePullSecrets {
+			secrets = append(secrets, secret.Name)
+		}
+		if len(secrets) != len(testCase.expectedSecrets) {
+			t.Errorf("%s: expected secrets %v, got %v", testCase.name, testCase.expectedSecrets, secrets)
+			continue
+		}
+		for i := range secrets {
+			if secrets[i] != testCase.expectedSecrets[i] {
+				t.Errorf("%s: expected secrets %v, got %v", testCase.name, testCase.expectedSecrets, secrets)
+				break
+			}
+		}
+	}
+}
+
+func TestValidateInjectsPullSecretsForMirroredImages(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "mirror.corp"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"gcr.io": "mirror.corp/gcr"},
+		PullSecrets:       map[string]string{"mirror.corp": "mirror-pull"},
+	}
+
+	response := validateFixture(t, "test_data/deployment.json", &settings)
+	if !response.Accepted {
+		t.Fatalf("Unexpected rejection: %v", *response.Message)
+	}
+	mutatedObject, err := json.Marshal(response.MutatedObject)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	secrets := gjson.GetBytes(mutatedObject, "spec.template.spec.imagePullSecrets.#.name").Raw
+	if secrets != `["mirror-pull"]` {
+		t.Errorf("Expected the mirror pull secret to be added, got %s", secrets)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxNameLength = 255
+	maxTagLength  = 128
+
+	defaultDomain       = "docker.io"
+	legacyDefaultDomain = "index.docker.io"
+	officialRepoPrefix  = "library/"
+	defaultTag          = "latest"
+
+	sha256EncodedLength = 64
+	sha512EncodedLength = 128
+)
+
+// imageReference holds the components of a container image reference, as
+// described by the distribution reference grammar:
+//
+//	reference := name [ ":" tag ] [ "@" digest ]
+//	name      := [ domain "/" ] path-component [ "/" path-component ]*
+//	domain    := host [ ":" port-number ]
+type imageReference struct {
+	// Registry is the domain of the reference, including the optional port.
+	// It is empty when the reference does not specify a domain.
+	Registry string
+	// Repository is the path of the image inside of the registry.
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Name returns the registry and repository of the reference, without tag
+// and digest.
+func (r imageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+func (r imageReference) String() string {
+	ref := r.Name()
+	if r.Tag != "" {
+		ref += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		ref += "@" + r.Digest
+	}
+	return ref
+}
+
+// parseImageReference splits an image reference into its components,
+// rejecting references that do not follow the distribution grammar.
+func parseImageReference(image string) (imageReference, error) {
+	if image == "" {
+		return imageReference{}, errors.New("reference is empty")
+	}
+
+	ref := imageReference{}
+	name := image
+
+	if idx := strings.IndexByte(name, '@'); idx >= 0 {
+		ref.Digest = name[idx+1:]
+		name = name[:idx]
+		if err := validateDigest(ref.Digest); err != nil {
+			return imageReference{}, err
+		}
+	}
+
+	// A colon after the last slash separates the tag. Colons before it
+	// belong to the port of the registry.
+	if idx := strings.LastIndexByte(name, ':'); idx > strings.LastIndexByte(name, '/') {
+		ref.Tag = name[idx+1:]
+		name = name[:idx]
+		if err := validateTag(ref.Tag); err != nil {
+			return imageReference{}, err
+		}
+	}
+
+	if name == "" {
+		return imageReference{}, errors.New("reference has no repository name")
+	}
+	if len(name) > maxNameLength {
+		return imageReference{}, fmt.Errorf("repository name must not be longer than %d characters", maxNameLength)
+	}
+
+	ref.Registry, ref.Repository = splitDomain(name)
+	if ref.Registry != "" {
+		if err := validateDomain(ref.Registry); err != nil {
+			return imageReference{}, err
+		}
+	}
+	if err := validateRepository(ref.Repository); err != nil {
+		return imageReference{}, err
+	}
+
+	return ref, nil
+}
+
+// parseNormalizedImageReference parses the image and fills in the parts
+// the container runtime would assume, so that short names can be compared
+// against fully qualified trusted registries.
+func parseNormalizedImageReference(image string) (imageReference, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return imageReference{}, err
+	}
+	return ref.Normalize(), nil
+}
+
+// Normalize resolves the reference the same way the kubelet and containerd
+// do: references without a domain come from docker.io, "index.docker.io" is
+// an alias of docker.io, single component Docker Hub repositories live
+// under "library/", and references without tag and digest use "latest".
+func (r imageReference) Normalize() imageReference {
+	switch r.Registry {
+	case "", legacyDefaultDomain:
+		r.Registry = defaultDomain
+	}
+	if r.Registry == defaultDomain && !strings.Contains(r.Repository, "/") {
+		r.Repository = officialRepoPrefix + r.Repository
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = defaultTag
+	}
+	return r
+}
+
+// normalizeRegistryEntry applies the Docker Hub domain alias to a trusted
+// registry entry. Unlike image references, entries are not expanded with
+// "library/": "docker.io/someuser" names a user namespace, not an image.
+func normalizeRegistryEntry(entry string) string {
+	entry = strings.TrimSuffix(entry, "/")
+	if entry == legacyDefaultDomain || strings.HasPrefix(entry, legacyDefaultDomain+"/") {
+		return defaultDomain + strings.TrimPrefix(entry, legacyDefaultDomain)
+	}
+	return entry
+}
+
+// splitDomain separates the domain from the repository path. The first
+// component of a name is only considered a domain when it looks like a
+// host name: it contains a dot or a port, is "localhost", or has uppercase
+// letters, which are not allowed in repository paths.
+func splitDomain(name string) (string, string) {
+	idx := strings.IndexByte(name, '/')
+	if idx < 0 {
+		return "", name
+	}
+
+	first := name[:idx]
+	if strings.ContainsAny(first, ".:") || first == "localhost" || strings.ToLower(first) != first {
+		return first, name[idx+1:]
+	}
+	return "", name
+}
+
+func validateDomain(domain string) error {
+	host := domain
+	port := ""
+
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 {
+			return fmt.Errorf("invalid registry %q: unterminated IPv6 address", domain)
+		}
+		if !isIPv6(host[1:end]) {
+			return fmt.Errorf("invalid registry %q: malformed IPv6 address", domain)
+		}
+		rest := host[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return fmt.Errorf("invalid registry %q", domain)
+			}
+			port = rest[1:]
+			if err := validatePort(domain, port); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if idx := strings.IndexByte(host, ':'); idx >= 0 {
+		host, port = host[:idx], host[idx+1:]
+		if err := validatePort(domain, port); err != nil {
+			return err
+		}
+	}
+
+	for _, component := range strings.Split(host, ".") {
+		if !isDomainComponent(component) {
+			return fmt.Errorf("invalid registry %q: malformed host name", domain)
+		}
+	}
+	return nil
+}
+
+func validatePort(domain, port string) error {
+	if port == "" || strings.Trim(port, "0123456789") != "" {
+		return fmt.Errorf("invalid registry %q: malformed port", domain)
+	}
+	return nil
+}
+
+func validateRepository(repository string) error {
+	for _, component := range strings.Split(repository, "/") {
+		if !isPathComponent(component) {
+			return fmt.Errorf("invalid repository %q: path components must be lowercase alphanumerics separated by '.', '_', '__' or '-'", repository)
+		}
+	}
+	return nil
+}
+
+func validateTag(tag string) error {
+	if tag == "" || len(tag) > maxTagLength {
+		return fmt.Errorf("invalid tag %q: must be between 1 and %d characters", tag, maxTagLength)
+	}
+	if !isWordChar(tag[0]) {
+		return fmt.Errorf("invalid tag %q: must start with a letter, a digit or '_'", tag)
+	}
+	for i := 1; i < len(tag); i++ {
+		if !isWordChar(tag[i]) && tag[i] != '.' && tag[i] != '-' {
+			return fmt.Errorf("invalid tag %q: only letters, digits, '_', '.' and '-' are allowed", tag)
+		}
+	}
+	return nil
+}
+
+// validateDigest checks the digest against the OCI grammar
+// `algorithm ":" encoded` and the encoding of the registered algorithms:
+// sha256 and sha512 digests are 64 and 128 lowercase hexadecimal characters.
+// Other algorithms are not supported by container runtimes and are rejected.
+func validateDigest(digest string) error {
+	algorithm, encoded, found := strings.Cut(digest, ":")
+	if !found || !isDigestAlgorithm(algorithm) || encoded == "" {
+		return fmt.Errorf("invalid digest %q: must be in the form 'algorithm:encoded'", digest)
+	}
+
+	var length int
+	switch algorithm {
+	case "sha256":
+		length = sha256EncodedLength
+	case "sha512":
+		length = sha512EncodedLength
+	default:
+		return fmt.Errorf("invalid digest %q: unsupported algorithm '%s', must be sha256 or sha512", digest, algorithm)
+	}
+	if len(encoded) != length {
+		return fmt.Errorf("invalid digest %q: %s digests must be %d hexadecimal characters", digest, algorithm, length)
+	}
+	for i := range len(encoded) {
+		if !isLowerHexDigit(encoded[i]) {
+			return fmt.Errorf("invalid digest %q: only lowercase hexadecimal characters are allowed", digest)
+		}
+	}
+	return nil
+}
+
+func isDomainComponent(component string) bool {
+	if component == "" || component[0] == '-' || component[len(component)-1] == '-' {
+		return false
+	}
+	for i := range len(component) {
+		if !isAlphaNumeric(component[i]) && component[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// isPathComponent reports whether the component matches
+// `[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*`.
+func isPathComponent(component string) bool {
+	if component == "" || !isLowerAlphaNumeric(component[0]) || !isLowerAlphaNumeric(component[len(component)-1]) {
+		return false
+	}
+	for i := 1; i < len(component); i++ {
+		c := component[i]
+		if isLowerAlphaNumeric(c) {
+			continue
+		}
+		switch c {
+		case '.':
+			if !isLowerAlphaNumeric(component[i-1]) {
+				return false
+			}
+		case '_':
+			// "__" is allowed, "___" and "._" are not.
+			if !isLowerAlphaNumeric(component[i-1]) && (component[i-1] != '_' || i < 2 || !isLowerAlphaNumeric(component[i-2])) {
+				return false
+			}
+		case '-':
+			if !isLowerAlphaNumeric(component[i-1]) && component[i-1] != '-' {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isDigestAlgorithm reports whether the algorithm matches
+// `[a-z0-9]+(?:[.+_-][a-z0-9]+)*`.
+func isDigestAlgorithm(algorithm string) bool {
+	if algorithm == "" || !isLowerAlphaNumeric(algorithm[len(algorithm)-1]) {
+		return false
+	}
+	for i := range len(algorithm) {
+		c := algorithm[i]
+		if isLowerAlphaNumeric(c) {
+			continue
+		}
+		if !strings.ContainsRune(".+_-", rune(c)) || i == 0 || !isLowerAlphaNumeric(algorithm[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIPv6(address string) bool {
+	if !strings.Contains(address, ":") {
+		return false
+	}
+	for i := range len(address) {
+		c := address[i]
+		if !isHexDigit(c) && c != ':' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLowerAlphaNumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+func isAlphaNumeric(c byte) bool {
+	return isLowerAlphaNumeric(c) || (c >= 'A' && c <= 'Z')
+}
+
+func isWordChar(c byte) bool {
+	return isAlphaNumeric(c) || c == '_'
+}
+
+func isLowerHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	cases := []struct {
+		image       string
+		expected    imageReference
+		expectedErr bool
+	}{
+		{
+			image:    "nginx",
+			expected: imageReference{Repository: "nginx"},
+		},
+		{
+			image:    "library/nginx:1.25",
+			expected: imageReference{Repository: "library/nginx", Tag: "1.25"},
+		},
+		{
+			image:    "quay.io/some/image:tag",
+			expected: imageReference{Registry: "quay.io", Repository: "some/image", Tag: "tag"},
+		},
+		{
+			image:    "localhost/app",
+			expected: imageReference{Registry: "localhost", Repository: "app"},
+		},
+		{
+			image:    "registry.corp:5000/team/app:v1.2.3",
+			expected: imageReference{Registry: "registry.corp:5000", Repository: "team/app", Tag: "v1.2.3"},
+		},
+		{
+			image:    "[fe80::1]:5000/app",
+			expected: imageReference{Registry: "[fe80::1]:5000", Repository: "app"},
+		},
+		{
+			image: "quay.io/some/image:tag@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			expected: imageReference{
+				Registry: "quay.io", Repository: "some/image", Tag: "tag", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			},
+		},
+		{
+			image:    "gcr.io/some/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			expected: imageReference{Registry: "gcr.io", Repository: "some/image", Digest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			image: "quay.io/some/image@sha512:" +
+				"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+			expected: imageReference{
+				Registry: "quay.io", Repository: "some/image",
+				Digest: "sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+			},
+		},
+		{
+			image:    "registry.corp/a__b/c-d/e.f",
+			expected: imageReference{Registry: "registry.corp", Repository: "a__b/c-d/e.f"},
+		},
+		{image: "", expectedErr: true},
+		{image: ":tag", expectedErr: true},
+		{image: "quay.io/", expectedErr: true},
+		{image: "quay.io//image", expectedErr: true},
+		{image: "quay.io/Some/Image", expectedErr: true},
+		{image: "quay.io/image:", expectedErr: true},
+		{image: "quay.io/image:-tag", expectedErr: true},
+		{image: "quay.io/image@sha256", expectedErr: true},
+		{image: "quay.io/image@:1234", expectedErr: true},
+		{image: "quay.io/image@sha256:not/hex", expectedErr: true},
+		{image: "quay.io/image@sha256:1234567890abcdef", expectedErr: true},
+		{image: "quay.io/image@sha256:E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", expectedErr: true},
+		{image: "quay.io/image@sha512:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", expectedErr: true},
+		{image: "quay.io/image@md5:d41d8cd98f00b204e9800998ecf8427e", expectedErr: true},
+		{image: "-quay.io/image", expectedErr: true},
+		{image: "quay.io:port/image", expectedErr: true},
+		{image: "[fe80::1/image", expectedErr: true},
+		{image: "quay.io/a___b", expectedErr: true},
+		{image: "quay.io/a._b", expectedErr: true},
+	}
+
+	for _, testCase := range cases {
+		ref, err := parseImageReference(testCase.image)
+		if testCase.expectedErr {
+			if err == nil {
+				t.Errorf("Expected an error parsing %q, got %+v", testCase.image, ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		if ref != testCase.expected {
+			t.Errorf("Parsing %q: expected %+v, got %+v", testCase.image, testCase.expected, ref)
+		}
+		if ref.String() != testCase.image {
+			t.Errorf("Expected %q to round trip, got %q", testCase.image, ref.String())
+		}
+	}
+}
+
+func TestNormalizeImageReference(t *testing.T) {
+	cases := []struct {
+		image    string
+		expected string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.25", "docker.io/library/nginx:1.25"},
+		{"library/nginx", "docker.io/library/nginx:latest"},
+		{"docker.io/nginx", "docker.io/library/nginx:latest"},
+		{"index.docker.io/library/nginx:latest", "docker.io/library/nginx:latest"},
+		{"someuser/app", "docker.io/someuser/app:latest"},
+		{"nginx@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "docker.io/library/nginx@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"quay.io/app", "quay.io/app:latest"},
+		{"quay.io/app:v1@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "quay.io/app:v1@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"localhost:5000/app", "localhost:5000/app:latest"},
+	}
+
+	for _, testCase := range cases {
+		ref, err := parseNormalizedImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		if ref.String() != testCase.expected {
+			t.Errorf("Normalizing %q: expected %q, got %q", testCase.image, testCase.expected, ref.String())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a semantic version, as described by https://semver.org.
+// Build metadata is ignored, since it does not take part in precedence.
+type version struct {
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+// parseVersion parses a MAJOR.MINOR.PATCH version with optional pre-release
+// and build metadata. A leading "v", as commonly used in image tags, is
+// accepted.
+func parseVersion(value string) (version, error) {
+	core := strings.TrimPrefix(value, "v")
+	core, _, _ = strings.Cut(core, "+")
+	core, prerelease, hasPrerelease := strings.Cut(core, "-")
+
+	numbers := strings.Split(core, ".")
+	if len(numbers) != 3 {
+		return version{}, fmt.Errorf("invalid version '%s': must be in the form MAJOR.MINOR.PATCH", value)
+	}
+	var parsed [3]uint64
+	for i, number := range numbers {
+		if !isNumericIdentifier(number) {
+			return version{}, fmt.Errorf("invalid version '%s': malformed number '%s'", value, number)
+		}
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return version{}, fmt.Errorf("invalid version '%s': %w", value, err)
+		}
+		parsed[i] = n
+	}
+
+	v := version{major: parsed[0], minor: parsed[1], patch: parsed[2]}
+	if hasPrerelease {
+		v.prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range v.prerelease {
+			if identifier == "" || strings.Trim(identifier, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+				return version{}, fmt.Errorf("invalid version '%s': malformed pre-release '%s'", value, prerelease)
+			}
+		}
+	}
+	return v, nil
+}
+
+// isNumericIdentifier reports whether the value is a number without leading
+// zeros.
+func isNumericIdentifier(value string) bool {
+	if value == "" || (len(value) > 1 && value[0] == '0') {
+		return false
+	}
+	return strings.Trim(value, "0123456789") == ""
+}
+
+// compare returns -1, 0 or 1 depending on whether v has a lower, the same
+// or a higher precedence than other.
+func (v version) compare(other version) int {
+	for _, pair := range [][2]uint64{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// A pre-release version has a lower precedence than the normal version.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		if c := comparePrereleaseIdentifiers(v.prerelease[i], other.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(v.prerelease) < len(other.prerelease):
+		return -1
+	case len(v.prerelease) > len(other.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// comparePrereleaseIdentifiers compares numeric identifiers numerically and
+// the others lexically, numeric identifiers having a lower precedence.
+func comparePrereleaseIdentifiers(a, b string) int {
+	aNumeric := strings.Trim(a, "0123456789") == ""
+	bNumeric := strings.Trim(b, "0123456789") == ""
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			return compareInts(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionComparator is a single constraint of a range, such as ">=2.0.0".
+type versionComparator struct {
+	operator string
+	version  version
+}
+
+func (c versionComparator) matches(v version) bool {
+	result := v.compare(c.version)
+	switch c.operator {
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	default:
+		return result == 0
+	}
+}
+
+// versionRange is a set of alternatives separated by "||", each of them a
+// list of comparators separated by spaces that must all match, for example
+// ">=2.0.0 <3.0.0 || >=4.1.0".
+type versionRange struct {
+	expression   string
+	alternatives [][]versionComparator
+}
+
+func (r *versionRange) String() string {
+	return r.expression
+}
+
+// parseVersionRange parses a range whose comparators use the operators
+// "<", "<=", ">", ">=" and "=", an exact version being written with or
+// without "=".
+func parseVersionRange(expression string) (*versionRange, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, errors.New("range is empty")
+	}
+
+	r := &versionRange{expression: expression}
+	for _, alternative := range strings.Split(expression, "||") {
+		fields := strings.Fields(alternative)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid range '%s': empty alternative", expression)
+		}
+		comparators := make([]versionComparator, 0, len(fields))
+		for _, field := range fields {
+			comparator, err := parseVersionComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range '%s': %w", expression, err)
+			}
+			comparators = append(comparators, comparator)
+		}
+		r.alternatives = append(r.alternatives, comparators)
+	}
+	return r, nil
+}
+
+func parseVersionComparator(field string) (versionComparator, error) {
+	operator := ""
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(field, candidate) {
+			operator = candidate
+			break
+		}
+	}
+	v, err := parseVersion(strings.TrimPrefix(field, operator))
+	if err != nil {
+		return versionComparator{}, err
+	}
+	return versionComparator{operator: operator, version: v}, nil
+}
+
+// contains reports whether the version satisfies any alternative of the
+// range. As with npm ranges, a pre-release version only satisfies an
+// alternative with a comparator on a pre-release of the same
+// MAJOR.MINOR.PATCH, so that ">=2.0.0 <3.0.0" does not admit "3.0.0-rc.1".
+func (r *versionRange) contains(v version) bool {
+	for _, comparators := range r.alternatives {
+		if len(v.prerelease) > 0 && !allowsPrerelease(comparators, v) {
+			continue
+		}
+		matches := true
+		for _, comparator := range comparators {
+			if !comparator.matches(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPrerelease reports whether a comparator opts in to the pre-releases
+// of the version.
+func allowsPrerelease(comparators []versionComparator, v version) bool {
+	for _, comparator := range comparators {
+		c := comparator.version
+		if len(c.prerelease) > 0 && c.major == v.major && c.minor == v.minor && c.patch == v.patch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		value       string
+		expected    version
+		expectedErr bool
+	}{
+		{value: "2.1.0", expected: version{major: 2, minor: 1}},
+		{value: "v10.20.30", expected: version{major: 10, minor: 20, patch: 30}},
+		{value: "1.0.0-rc.1", expected: version{major: 1, prerelease: []string{"rc", "1"}}},
+		{value: "1.0.0+build.5", expected: version{major: 1}},
+		{value: "1.0.0-alpha+build", expected: version{major: 1, prerelease: []string{"alpha"}}},
+		{value: "1.0", expectedErr: true},
+		{value: "1.0.0.0", expectedErr: true},
+		{value: "01.0.0", expectedErr: true},
+		{value: "1.x.0", expectedErr: true},
+		{value: "1.0.0-", expectedErr: true},
+		{value: "1.0.0-rc..1", expectedErr: true},
+		{value: "latest", expectedErr: true},
+	}
+
+	for _, testCase := range cases {
+		v, err := parseVersion(testCase.value)
+		if testCase.expectedErr {
+			if err == nil {
+				t.Errorf("Expected an error parsing %q, got %+v", testCase.value, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.value, err)
+			continue
+		}
+		if v.compare(testCase.expected) != 0 || len(v.prerelease) != len(testCase.expected.prerelease) {
+			t.Errorf("Parsing %q: expected %+v, got %+v", testCase.value, testCase.expected, v)
+		}
+	}
+}
+
+func TestVersionPrecedence(t *testing.T) {
+	// Ordered by increasing precedence, as in the semantic versioning
+	// specification.
+	versions := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "2.0.0",
+	}
+
+	for i := 1; i < len(versions); i++ {
+		lower, err := parseVersion(versions[i-1])
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		higher, err := parseVersion(versions[i])
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if lower.compare(higher) != -1 || higher.compare(lower) != 1 {
+			t.Errorf("Expected %s to have a lower precedence than %s", versions[i-1], versions[i])
+		}
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	cases := []struct {
+		expression string
+		version    string
+		expected   bool
+	}{
+		{">=2.0.0 <3.0.0", "2.0.0", true},
+		{">=2.0.0 <3.0.0", "v2.9.1", true},
+		{">=2.0.0 <3.0.0", "3.0.0", false},
+		{">=2.0.0 <3.0.0", "1.9.9", false},
+		// Pre-releases only match comparators opting in to the same
+		// MAJOR.MINOR.PATCH, as with npm ranges
+		{">=2.0.0 <3.0.0", "3.0.0-rc.1", false},
+		{">=2.0.0 <3.0.0", "2.5.0-rc.1", false},
+		{">=2.0.0-rc.1 <3.0.0", "2.0.0-rc.2", true},
+		{">=2.0.0-rc.1 <3.0.0", "2.0.0-beta.1", false},
+		{">=2.0.0-rc.1 <3.0.0", "2.1.0-rc.1", false},
+		{">=2.0.0 <3.0.0-rc.2", "3.0.0-rc.1", true},
+		{"<1.0.0 || >=2.0.0-rc.1", "2.0.0-rc.1", true},
+		{">2.0.0", "2.0.0", false},
+		{"<=2.0.0", "2.0.0", true},
+		{"=1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{"<1.0.0 || >=2.0.0", "0.9.0", true},
+		{"<1.0.0 || >=2.0.0", "1.5.0", false},
+		{"<1.0.0 || >=2.0.0", "2.5.0", true},
+	}
+
+	for _, testCase := range cases {
+		r, err := parseVersionRange(testCase.expression)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.expression, err)
+			continue
+		}
+		v, err := parseVersion(testCase.version)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.version, err)
+			continue
+		}
+		if r.contains(v) != testCase.expected {
+			t.Errorf("Expected %s contains %s to be %v", testCase.expression, testCase.version, testCase.expected)
+		}
+	}
+
+	for _, expression := range []string{"", "   ", ">=2.0", "~2.0.0", ">=2.0.0 ||", "=>2.0.0"} {
+		if _, err := parseVersionRange(expression); err == nil {
+			t.Errorf("Expected an error parsing range %q", expression)
+		}
+	}
+}
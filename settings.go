@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	kubewarden "github.com/kubewarden/policy-sdk-go"
@@ -12,11 +13,154 @@ import (
 
 type Settings struct {
 	TrustedRegistries mapset.Set[string] `json:"trusted_registries"`
+	// TrustedRegistriesConfigMap names a ConfigMap key listing more trusted
+	// registries, read through the Kubernetes capability of the host at
+	// evaluation time and merged with TrustedRegistries.
+	TrustedRegistriesConfigMap *ConfigMapReference `json:"trusted_registries_config_map,omitempty"`
+	// Blocked registries and repositories are rejected even when they live
+	// below a trusted registry: deny beats allow.
+	Blocked mapset.Set[string] `json:"blocked"`
+	// TrustedPatterns are regular expressions matching the whole normalized
+	// image name, registry and repository without tag and digest.
+	TrustedPatterns mapset.Set[string] `json:"trusted_patterns"`
+	// NamespaceRules replace the trusted registries and patterns above for
+	// the namespaces they select, which act as the default otherwise.
+	NamespaceRules []NamespaceRule `json:"namespace_rules"`
+	// NamespaceLabelsFallback are the registries trusted in place of the
+	// namespace rules selecting namespaces by label, when the labels of the
+	// namespace cannot be looked up.
+	NamespaceLabelsFallback []string `json:"namespace_labels_fallback"`
+	// ExemptNamespaces are exact namespace names or globs using `*` whose
+	// requests are accepted without evaluating their images.
+	ExemptNamespaces mapset.Set[string] `json:"exempt_namespaces"`
+	// ExemptUsers, ExemptGroups and ExemptServiceAccounts accept the requests
+	// made by the matching users, for example CI bootstrap service accounts
+	// or cluster admins doing incident recovery. Entries may use `*` globs;
+	// service accounts are written as `<namespace>:<name>`.
+	ExemptUsers           mapset.Set[string] `json:"exempt_users"`
+	ExemptGroups          mapset.Set[string] `json:"exempt_groups"`
+	ExemptServiceAccounts mapset.Set[string] `json:"exempt_service_accounts"`
+	// BreakGlassMaxDuration enables the break-glass annotations of objects
+	// and bounds how far in the future their expiry may be, as a Go
+	// duration such as "72h".
+	BreakGlassMaxDuration string `json:"break_glass_max_duration,omitempty"`
+	// ImageExceptions allow single images from untrusted registries. Blocked
+	// entries still take precedence over them.
+	ImageExceptions []ImageException `json:"image_exceptions"`
+	// DisallowedTags are tags, such as "latest", that trusted images must
+	// not use because they are mutable.
+	DisallowedTags mapset.Set[string] `json:"disallowed_tags"`
+	// RejectImplicitTag rejects images without tag and digest, which the
+	// runtime pulls as "latest".
+	RejectImplicitTag bool `json:"reject_implicit_tag"`
+	// RequireDigest rejects images that are not pinned by digest.
+	RequireDigest bool `json:"require_digest"`
+	// RegistryRules override the image policy above for the registries they
+	// select.
+	RegistryRules []RegistryRule `json:"registry_rules"`
+	// DigestAllowlists replace every other check but the blocked entries in
+	// the namespaces they select: only the approved digests are accepted.
+	DigestAllowlists []DigestAllowlist `json:"digest_allowlists"`
+	// Mutate enables the mutating mode: images matching a source prefix of
+	// Mirrors are rewritten to the mirror prefix before being validated.
+	Mutate  bool              `json:"mutate"`
+	Mirrors map[string]string `json:"mirrors"`
+	// PullSecrets maps registry prefixes to the name of the pull secret their
+	// images need, which the mutating mode adds to the pod spec.
+	PullSecrets map[string]string `json:"pull_secrets"`
+	// PinDigests makes the mutating mode append to images from trusted
+	// registries the digest their tag points to, resolved by the host.
+	// Requests whose images cannot be resolved are rejected, unless
+	// PinDigestsFailOpen leaves those images unpinned.
+	PinDigests         bool `json:"pin_digests"`
+	PinDigestsFailOpen bool `json:"pin_digests_fail_open"`
+	// RequiresCredentials are registry hosts whose images are rejected when
+	// neither the pull secrets of the pod nor the ones of its service
+	// account have credentials for them.
+	RequiresCredentials mapset.Set[string] `json:"requires_credentials"`
+
+	defaultRules          trustRules
+	blockedPatterns       []registryPattern
+	exemptNamespaces      []string
+	exemptUsers           []string
+	exemptGroups          []string
+	exemptServiceAccounts []string
+	breakGlassMaxDuration time.Duration
+	mirrors               []mirrorRule
+	pullSecrets           []pullSecretRule
+	namespaceFallback     NamespaceRule
+	credentialRegistries  mapset.Set[string]
+}
+
+// NamespaceRule holds the trusted registries and patterns of the namespaces
+// it selects. Rules are evaluated in order and the first one selecting the
+// namespace of the request wins.
+type NamespaceRule struct {
+	// Namespaces are exact namespace names or globs using `*`.
+	Namespaces []string `json:"namespaces"`
+	// NamespaceLabels select the namespaces having all these labels, looked
+	// up through the Kubernetes capability of the host. When both are set,
+	// a namespace must match Namespaces and NamespaceLabels.
+	NamespaceLabels   map[string]string `json:"namespace_labels,omitempty"`
+	TrustedRegistries []string          `json:"trusted_registries"`
+	TrustedPatterns   []string          `json:"trusted_patterns"`
+	// RequireDigest replaces the global require_digest flag in the selected
+	// namespaces.
+	RequireDigest *bool `json:"require_digest,omitempty"`
+
+	rules trustRules
+}
+
+// selectsName reports whether the namespace names of the rule select the
+// namespace. Rules selecting by label only select every name.
+func (r *NamespaceRule) selectsName(namespace string) bool {
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, selector := range r.Namespaces {
+		if matchNamespace(selector, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsLabels reports whether the namespace labels match every label of
+// the rule.
+func (r *NamespaceRule) selectsLabels(labels map[string]string) bool {
+	for key, value := range r.NamespaceLabels {
+		if actual, found := labels[key]; !found || actual != value {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *Settings) UnmarshalJSON(data []byte) error {
 	rawSettings := struct {
-		TrustedRegistries []string `json:"trusted_registries"`
+		TrustedRegistries          []string            `json:"trusted_registries"`
+		TrustedRegistriesConfigMap *ConfigMapReference `json:"trusted_registries_config_map"`
+		Blocked                    []string            `json:"blocked"`
+		TrustedPatterns            []string            `json:"trusted_patterns"`
+		NamespaceRules             []NamespaceRule     `json:"namespace_rules"`
+		NamespaceLabelsFallback    []string            `json:"namespace_labels_fallback"`
+		ExemptNamespaces           []string            `json:"exempt_namespaces"`
+		ExemptUsers                []string            `json:"exempt_users"`
+		ExemptGroups               []string            `json:"exempt_groups"`
+		ExemptServiceAccounts      []string            `json:"exempt_service_accounts"`
+		BreakGlassMaxDuration      string              `json:"break_glass_max_duration"`
+		ImageExceptions            []ImageException    `json:"image_exceptions"`
+		DisallowedTags             []string            `json:"disallowed_tags"`
+		RejectImplicitTag          bool                `json:"reject_implicit_tag"`
+		RequireDigest              bool                `json:"require_digest"`
+		RegistryRules              []RegistryRule      `json:"registry_rules"`
+		DigestAllowlists           []DigestAllowlist   `json:"digest_allowlists"`
+		Mutate                     bool                `json:"mutate"`
+		Mirrors                    map[string]string   `json:"mirrors"`
+		PullSecrets                map[string]string   `json:"pull_secrets"`
+		PinDigests                 bool                `json:"pin_digests"`
+		PinDigestsFailOpen         bool                `json:"pin_digests_fail_open"`
+		RequiresCredentials        []string            `json:"requires_credentials"`
 	}{}
 
 	err := json.Unmarshal(data, &rawSettings)
@@ -25,6 +169,28 @@ func (s *Settings) UnmarshalJSON(data []byte) error {
 	}
 
 	s.TrustedRegistries = mapset.NewThreadUnsafeSet[string](rawSettings.TrustedRegistries...)
+	s.TrustedRegistriesConfigMap = rawSettings.TrustedRegistriesConfigMap
+	s.Blocked = mapset.NewThreadUnsafeSet[string](rawSettings.Blocked...)
+	s.TrustedPatterns = mapset.NewThreadUnsafeSet[string](rawSettings.TrustedPatterns...)
+	s.NamespaceRules = rawSettings.NamespaceRules
+	s.NamespaceLabelsFallback = rawSettings.NamespaceLabelsFallback
+	s.ExemptNamespaces = mapset.NewThreadUnsafeSet[string](rawSettings.ExemptNamespaces...)
+	s.ExemptUsers = mapset.NewThreadUnsafeSet[string](rawSettings.ExemptUsers...)
+	s.ExemptGroups = mapset.NewThreadUnsafeSet[string](rawSettings.ExemptGroups...)
+	s.ExemptServiceAccounts = mapset.NewThreadUnsafeSet[string](rawSettings.ExemptServiceAccounts...)
+	s.BreakGlassMaxDuration = rawSettings.BreakGlassMaxDuration
+	s.ImageExceptions = rawSettings.ImageExceptions
+	s.DisallowedTags = mapset.NewThreadUnsafeSet[string](rawSettings.DisallowedTags...)
+	s.RejectImplicitTag = rawSettings.RejectImplicitTag
+	s.RequireDigest = rawSettings.RequireDigest
+	s.RegistryRules = rawSettings.RegistryRules
+	s.DigestAllowlists = rawSettings.DigestAllowlists
+	s.Mutate = rawSettings.Mutate
+	s.Mirrors = rawSettings.Mirrors
+	s.PullSecrets = rawSettings.PullSecrets
+	s.PinDigests = rawSettings.PinDigests
+	s.PinDigestsFailOpen = rawSettings.PinDigestsFailOpen
+	s.RequiresCredentials = mapset.NewThreadUnsafeSet[string](rawSettings.RequiresCredentials...)
 
 	return nil
 }
@@ -36,17 +202,174 @@ func NewSettingsFromValidationReq(validationReq *kubewarden_protocol.ValidationR
 		return Settings{}, err
 	}
 
+	if err = settings.compile(); err != nil {
+		return Settings{}, err
+	}
+
 	return settings, nil
 }
 
+// compile builds the matchers used at evaluation time out of the raw
+// settings, failing on entries that cannot be compiled.
+func (s *Settings) compile() error {
+	var err error
+	s.defaultRules, err = compileTrustRules(toSlice(s.TrustedRegistries), toSlice(s.TrustedPatterns))
+	if err != nil {
+		return err
+	}
+	if s.TrustedRegistriesConfigMap != nil {
+		if err = s.TrustedRegistriesConfigMap.validate(); err != nil {
+			return fmt.Errorf("trusted_registries_config_map: %w", err)
+		}
+	}
+	s.blockedPatterns, err = compileRegistryPatterns(toSlice(s.Blocked))
+	if err != nil {
+		return fmt.Errorf("blocked: %w", err)
+	}
+	if err = s.compileExemptions(); err != nil {
+		return err
+	}
+	for i := range s.ImageExceptions {
+		if err = s.ImageExceptions[i].compile(); err != nil {
+			return fmt.Errorf("image_exceptions[%d]: %w", i, err)
+		}
+	}
+	if err = validateTags(sortedSlice(s.DisallowedTags)); err != nil {
+		return fmt.Errorf("disallowed_tags: %w", err)
+	}
+	for i := range s.RegistryRules {
+		if err = s.RegistryRules[i].compile(); err != nil {
+			return fmt.Errorf("registry_rules[%d]: %w", i, err)
+		}
+	}
+	for i := range s.DigestAllowlists {
+		if err = s.DigestAllowlists[i].compile(); err != nil {
+			return fmt.Errorf("digest_allowlists[%d]: %w", i, err)
+		}
+	}
+	if s.Mutate && len(s.Mirrors) == 0 && len(s.PullSecrets) == 0 && !s.PinDigests {
+		return errors.New("mutate: no mirrors, pull secrets or digest pinning provided")
+	}
+	if s.PinDigests && !s.Mutate {
+		return errors.New("pin_digests: requires mutate to be enabled")
+	}
+	s.mirrors, err = compileMirrors(s.Mirrors)
+	if err != nil {
+		return fmt.Errorf("mirrors: %w", err)
+	}
+	s.pullSecrets, err = compilePullSecrets(s.PullSecrets)
+	if err != nil {
+		return fmt.Errorf("pull_secrets: %w", err)
+	}
+	s.credentialRegistries, err = compileCredentialRegistries(sortedSlice(s.RequiresCredentials))
+	if err != nil {
+		return fmt.Errorf("requires_credentials: %w", err)
+	}
+	for i := range s.NamespaceRules {
+		rule := &s.NamespaceRules[i]
+		if len(rule.Namespaces) == 0 && len(rule.NamespaceLabels) == 0 {
+			return fmt.Errorf("namespace_rules[%d]: no namespaces or namespace labels provided", i)
+		}
+		for _, selector := range rule.Namespaces {
+			if err = validateNamespaceSelector(selector); err != nil {
+				return fmt.Errorf("namespace_rules[%d]: %w", i, err)
+			}
+		}
+		if err = validateNamespaceLabels(rule.NamespaceLabels); err != nil {
+			return fmt.Errorf("namespace_rules[%d]: %w", i, err)
+		}
+		rule.rules, err = compileTrustRules(rule.TrustedRegistries, rule.TrustedPatterns)
+		if err != nil {
+			return fmt.Errorf("namespace_rules[%d]: %w", i, err)
+		}
+		if rule.rules.empty() {
+			return fmt.Errorf("namespace_rules[%d]: no trusted registries provided", i)
+		}
+	}
+	s.namespaceFallback.rules, err = compileTrustRules(s.NamespaceLabelsFallback, nil)
+	if err != nil {
+		return fmt.Errorf("namespace_labels_fallback: %w", err)
+	}
+	if !s.namespaceFallback.rules.empty() && !s.selectsNamespaceLabels() {
+		return errors.New("namespace_labels_fallback: no namespace rule selects namespaces by label")
+	}
+	return nil
+}
+
 func (s *Settings) Valid() (bool, error) {
-	if s.TrustedRegistries.Cardinality() == 0 {
+	if cardinality(s.TrustedRegistries) == 0 && cardinality(s.TrustedPatterns) == 0 &&
+		s.TrustedRegistriesConfigMap == nil && len(s.NamespaceRules) == 0 {
 		return false, errors.New("no trusted registries provided")
 	}
 
+	if err := s.compile(); err != nil {
+		return false, err
+	}
+
+	// A blocked entry may carve a repository out of a trusted registry, but
+	// blocking a trusted entry as a whole is contradictory.
+	trusted := append([]registryPattern(nil), s.defaultRules.registries...)
+	for _, rule := range s.NamespaceRules {
+		trusted = append(trusted, rule.rules.registries...)
+	}
+	trusted = append(trusted, s.namespaceFallback.rules.registries...)
+	for _, blocked := range s.blockedPatterns {
+		for _, entry := range trusted {
+			if blocked.Match(normalizeRegistryEntry(entry.String())) {
+				return false, fmt.Errorf("trusted registry '%s' is entirely blocked by '%s'", entry, blocked)
+			}
+		}
+		for i := range s.ImageExceptions {
+			exception := &s.ImageExceptions[i]
+			if exception.Image != "" && blocked.Match(exception.ref.Name()) {
+				return false, fmt.Errorf("image exception '%s' is blocked by '%s'", exception, blocked)
+			}
+		}
+	}
+
 	return true, nil
 }
 
+// trustRulesFor returns the trusted registries and patterns that apply to
+// the namespace of the request, along with the namespace rule they come
+// from, if any. When a rule selecting by label is reached and the labels of
+// the namespace could not be looked up, the namespace label fallback
+// applies.
+func (s *Settings) trustRulesFor(ctx requestContext) (trustRules, *NamespaceRule) {
+	for i := range s.NamespaceRules {
+		rule := &s.NamespaceRules[i]
+		if !rule.selectsName(ctx.Namespace) {
+			continue
+		}
+		if len(rule.NamespaceLabels) > 0 {
+			if ctx.namespaceLabelsUnavailable {
+				return s.namespaceFallback.rules, &s.namespaceFallback
+			}
+			if ctx.Namespace == "" || !rule.selectsLabels(ctx.NamespaceLabels) {
+				continue
+			}
+		}
+		return rule.rules, rule
+	}
+	return s.defaultRules, nil
+}
+
+// cardinality returns the number of elements of a set that may be nil.
+func cardinality(set mapset.Set[string]) int {
+	if set == nil {
+		return 0
+	}
+	return set.Cardinality()
+}
+
+// toSlice returns the elements of a set that may be nil.
+func toSlice(set mapset.Set[string]) []string {
+	if set == nil {
+		return nil
+	}
+	return set.ToSlice()
+}
+
 func validateSettings(payload []byte) ([]byte, error) {
 	settings := Settings{}
 	err := json.Unmarshal(payload, &settings)
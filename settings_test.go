@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
 )
 
 func TestParsingSettingsWithNoValueProvided(t *testing.T) {
@@ -74,3 +76,515 @@ func TestValidMethod(t *testing.T) {
 		}
 	}
 }
+
+func TestParsingSettingsWithBlockedEntries(t *testing.T) {
+	rawSettings := []byte(`{"trusted_registries": ["docker.io"], "blocked": ["docker.io/someuser"]}`)
+	settings := &Settings{}
+	if unmarshalErr := json.Unmarshal(rawSettings, settings); unmarshalErr != nil {
+		t.Errorf("Unexpected error %+v", unmarshalErr)
+	}
+
+	if !settings.Blocked.Contains("docker.io/someuser") {
+		t.Errorf("Expected Blocked to contain docker.io/someuser, got %v", settings.Blocked)
+	}
+
+	valid, validationErr := settings.Valid()
+	if !valid {
+		t.Errorf("Settings are reported as not valid: %+v", validationErr)
+	}
+}
+
+func TestValidMethodWithBlockedEntries(t *testing.T) {
+	tests := []struct {
+		trusted  []string
+		blocked  []string
+		expected bool
+	}{
+		// Blocking a repository inside of a trusted registry is the intended use
+		{[]string{"quay.io"}, []string{"quay.io/compromised/app"}, true},
+		{[]string{"docker.io/library"}, []string{"docker.io/someuser"}, true},
+		// Blocking a trusted entry, or a parent of it, is contradictory
+		{[]string{"quay.io"}, []string{"quay.io"}, false},
+		{[]string{"quay.io/org"}, []string{"quay.io/"}, false},
+		{[]string{"docker.io/library"}, []string{"index.docker.io"}, false},
+		{[]string{"quay.io", "gcr.io/project"}, []string{"gcr.io"}, false},
+		{[]string{"quay.io"}, []string{""}, false},
+	}
+
+	for _, test := range tests {
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string](test.trusted...),
+			Blocked:           mapset.NewThreadUnsafeSet[string](test.blocked...),
+		}
+		valid, validationErr := settings.Valid()
+		if valid != test.expected {
+			t.Errorf("Expected Valid() to be %v with trusted %v and blocked %v, got %v (%+v)",
+				test.expected, test.trusted, test.blocked, valid, validationErr)
+		}
+	}
+}
+
+func TestValidateSettingsWithGlobPatterns(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["*.registry.corp", "registry.corp/team-*/", "registry.corp/**/stable"]}`, true},
+		{`{"trusted_registries": ["registry.corp/team-**"]}`, false},
+		{`{"trusted_registries": ["**"]}`, false},
+		{`{"trusted_registries": ["quay.io"], "blocked": ["quay.io/[evil]"]}`, false},
+		{`{"trusted_registries": ["eu-1.registry.corp"], "blocked": ["*.registry.corp"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithTrustedPatterns(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_patterns": ["^registry\\.corp/(prod|stage)/[a-z0-9-]+$"]}`, true},
+		{`{"trusted_registries": ["quay.io"], "trusted_patterns": ["^gcr\\.io/"]}`, true},
+		// Not anchored, would also match evil.com/registry.corp/app
+		{`{"trusted_patterns": ["registry\\.corp/"]}`, false},
+		// Does not compile
+		{`{"trusted_patterns": ["^registry\\.corp/(prod"]}`, false},
+		// Lookarounds and backreferences are not supported by RE2
+		{`{"trusted_patterns": ["^registry\\.corp/(?!tmp)"]}`, false},
+		{`{"trusted_patterns": ["^(a)\\1"]}`, false},
+		// The anchor applies to every branch of a top level alternation
+		{`{"trusted_patterns": ["^registry\\.corp/.*|evil"]}`, true},
+		// Unbalanced parentheses cannot break out of the anchoring group
+		{`{"trusted_patterns": ["^registry\\.corp/.*)|(evil"]}`, false},
+		{`{"trusted_patterns": []}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestNewSettingsFromValidationReqCompilesPatterns(t *testing.T) {
+	settings, err := NewSettingsFromValidationReq(&kubewarden_protocol.ValidationRequest{
+		Settings: []byte(`{"trusted_patterns": ["^registry\\.corp/(prod|stage)/[a-z0-9-]+$"]}`),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %+v", err)
+	}
+	if len(settings.defaultRules.regexps) != 1 {
+		t.Errorf("Expected 1 compiled pattern, got %d", len(settings.defaultRules.regexps))
+	}
+
+	_, err = NewSettingsFromValidationReq(&kubewarden_protocol.ValidationRequest{
+		Settings: []byte(`{"trusted_patterns": ["^registry\\.corp/(prod"]}`),
+	})
+	if err == nil {
+		t.Errorf("Expected an error for a pattern that does not compile")
+	}
+}
+
+func TestValidateSettingsWithNamespaceRules(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["quay.io"], "namespace_rules": [
+			{"namespaces": ["kube-system", "monitoring-*"], "trusted_registries": ["registry.k8s.io"]}]}`, true},
+		// The default list may be empty when namespace rules are provided
+		{`{"namespace_rules": [{"namespaces": ["*"], "trusted_patterns": ["^registry\\.corp/"]}]}`, true},
+		{`{"namespace_rules": [{"trusted_registries": ["registry.k8s.io"]}]}`, false},
+		{`{"namespace_rules": [{"namespaces": [""], "trusted_registries": ["registry.k8s.io"]}]}`, false},
+		{`{"namespace_rules": [{"namespaces": ["Kube_System"], "trusted_registries": ["registry.k8s.io"]}]}`, false},
+		{`{"namespace_rules": [{"namespaces": ["kube-system"]}]}`, false},
+		{`{"namespace_rules": [{"namespaces": ["kube-system"], "trusted_registries": ["**"]}]}`, false},
+		{`{"namespace_rules": [{"namespaces": ["kube-system"], "trusted_patterns": ["registry"]}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "blocked": ["registry.k8s.io"], "namespace_rules": [
+			{"namespaces": ["kube-system"], "trusted_registries": ["registry.k8s.io/pause"]}]}`, false},
+		{`{"namespace_rules": "not an array"}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+// validateRawSettings runs the settings validation of the policy and returns
+// the decoded response.
+func validateRawSettings(t *testing.T, rawSettings string) kubewarden_protocol.SettingsValidationResponse {
+	t.Helper()
+
+	responsePayload, err := validateSettings([]byte(rawSettings))
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if unmarshalErr := json.Unmarshal(responsePayload, &response); unmarshalErr != nil {
+		t.Errorf("Unexpected error %+v", unmarshalErr)
+	}
+
+	return response
+}
+
+func TestValidateSettingsWithTemplates(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp/{{namespace}}/", "registry.corp/{{labels.team}}/"]}`, true},
+		{`{"namespace_rules": [{"namespaces": ["tenant-*"], "trusted_registries": ["registry.corp/tenants/{{namespace}}"]}]}`, true},
+		{`{"trusted_registries": ["registry.corp/{{cluster}}/"]}`, false},
+		{`{"trusted_registries": ["registry.corp/{{namespace"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithExemptNamespaces(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["quay.io"], "exempt_namespaces": ["kube-system", "cni-*"]}`, true},
+		{`{"trusted_registries": ["quay.io"], "exempt_namespaces": [""]}`, false},
+		{`{"trusted_registries": ["quay.io"], "exempt_namespaces": ["kube_system"]}`, false},
+		// Exemptions alone do not make the policy useful
+		{`{"exempt_namespaces": ["kube-system"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithUserExemptions(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["quay.io"], "exempt_users": ["admin@corp.example", "breakglass-*"],
+			"exempt_groups": ["system:masters"], "exempt_service_accounts": ["ci:bootstrap", "flux-*:*"]}`, true},
+		{`{"trusted_registries": ["quay.io"], "exempt_users": ["*"]}`, false},
+		{`{"trusted_registries": ["quay.io"], "exempt_groups": [""]}`, false},
+		{`{"trusted_registries": ["quay.io"], "exempt_service_accounts": ["bootstrap"]}`, false},
+		{`{"trusted_registries": ["quay.io"], "exempt_service_accounts": ["ci:"]}`, false},
+		{`{"trusted_registries": ["quay.io"], "exempt_service_accounts": ["system:serviceaccount:ci:bootstrap"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithBreakGlass(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["quay.io"], "break_glass_max_duration": "72h"}`, true},
+		{`{"trusted_registries": ["quay.io"], "break_glass_max_duration": "3 days"}`, false},
+		{`{"trusted_registries": ["quay.io"], "break_glass_max_duration": "-1h"}`, false},
+		{`{"trusted_registries": ["quay.io"], "break_glass_max_duration": "0s"}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithImageExceptions(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0",
+			"reason": "vendor appliance", "owner": "platform-team", "namespaces": ["vendor-*"], "expires": "2026-12-31T00:00:00Z"}]}`, true},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"digest": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "reason": "vendor appliance"}]}`, true},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0", "reason": " "}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"reason": "vendor appliance"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0",
+			"digest": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "reason": "vendor appliance"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "Vendor/Appliance", "reason": "vendor appliance"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"digest": "1234", "reason": "vendor appliance"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0",
+			"reason": "vendor appliance", "expires": "next week"}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "image_exceptions": [{"image": "vendor.example.com/appliance:1.0",
+			"reason": "vendor appliance", "namespaces": ["Vendor"]}]}`, false},
+		{`{"trusted_registries": ["quay.io"], "blocked": ["vendor.example.com"], "image_exceptions": [
+			{"image": "vendor.example.com/appliance:1.0", "reason": "vendor appliance"}]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithTagPolicy(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "disallowed_tags": ["latest", "dev"], "reject_implicit_tag": true,
+			"registry_rules": [{"registries": ["registry.corp/sandbox"], "disallowed_tags": [], "reject_implicit_tag": false}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "disallowed_tags": [":latest"]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"disallowed_tags": ["latest"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["*"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"], "disallowed_tags": ["-dev"]}]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithTagConstraints(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp/base/*"],
+			"allowed_tag_patterns": ["v\\d+\\.\\d+\\.\\d+"], "allowed_versions": ">=2.0.0 <3.0.0"}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"allowed_tag_patterns": ["(?<=v)\\d+"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"allowed_versions": ">=2.x"}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"allowed_versions": ">=2.0.0 ||"}]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithDigestAllowlists(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "digest_allowlists": [{"namespaces": ["pci-*"], "digests": [
+			"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"registry.corp/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"]}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "digest_allowlists": [{"digests": [
+			"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "digest_allowlists": [{"namespaces": ["pci"], "digests": []}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "digest_allowlists": [{"namespaces": ["pci"], "digests": ["sha256:1234"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "digest_allowlists": [{"namespaces": ["pci"], "digests": [
+			"registry.corp/App@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"]}]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithMirrors(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["mirror.corp"], "mutate": true, "mirrors": {"docker.io": "mirror.corp/dockerhub"}}`, true},
+		{`{"trusted_registries": ["mirror.corp"], "mirrors": {"docker.io": "mirror.corp/dockerhub"}}`, true},
+		{`{"trusted_registries": ["mirror.corp"], "mutate": true}`, false},
+		{`{"trusted_registries": ["mirror.corp"], "mutate": true, "mirrors": {"docker.io/*": "mirror.corp/dockerhub"}}`, false},
+		{`{"trusted_registries": ["mirror.corp"], "mutate": true, "mirrors": {"docker.io": "dockerhub"}}`, false},
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "mirrors": {"registry.corp": "registry.corp/cache"}}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithPullSecrets(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pull_secrets": {"registry.corp": "corp-pull", "quay.io/ourorg": "quay-pull"}}`, true},
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pull_secrets": {"registry.corp": "Corp_Pull"}}`, false},
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pull_secrets": {"registry.corp": ""}}`, false},
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pull_secrets": {"registry.*": "corp-pull"}}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithDigestPinning(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pin_digests": true}`, true},
+		{`{"trusted_registries": ["registry.corp"], "mutate": true, "pin_digests": true, "pin_digests_fail_open": true}`, true},
+		{`{"trusted_registries": ["registry.corp"], "pin_digests": true}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithSignatures(t *testing.T) {
+	key := strings.ReplaceAll(testPublicKey, "\n", `\n`)
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"], "verify_keys": ["` + key + `"]}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"issuer": "https://accounts.google.com", "subject": "release@corp.example"}]}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"issuer": "https://token.actions.githubusercontent.com", "subject_prefix": "https://github.com/corp"}]}]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"], "verify_keys": ["not a key"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"subject": "release@corp.example"}]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"issuer": "https://accounts.google.com"}]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"issuer": "https://accounts.google.com", "subject": "a@corp.example", "subject_prefix": "https://corp"}]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "registry_rules": [{"registries": ["registry.corp"],
+			"verify_keyless": [{"issuer": "https://accounts.google.com", "subject_regexp": ".*@corp.example"}]}]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithNamespaceLabels(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"namespace_rules": [{"namespace_labels": {"security-tier": "restricted"}, "trusted_registries": ["registry.corp"]}]}`, true},
+		{`{"namespace_rules": [{"namespaces": ["team-*"], "namespace_labels": {"security-tier": "lab"}, "trusted_registries": ["docker.io"]}],
+			"namespace_labels_fallback": ["registry.corp/restricted"]}`, true},
+		{`{"namespace_rules": [{"namespace_labels": {}, "trusted_registries": ["registry.corp"]}]}`, false},
+		{`{"namespace_rules": [{"namespace_labels": {"": "restricted"}, "trusted_registries": ["registry.corp"]}]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "namespace_labels_fallback": ["registry.corp/restricted"]}`, false},
+		{`{"namespace_rules": [{"namespace_labels": {"security-tier": "lab"}, "trusted_registries": ["docker.io"]}],
+			"namespace_labels_fallback": ["registry.corp/*restricted"]}`, true},
+		{`{"namespace_rules": [{"namespace_labels": {"security-tier": "lab"}, "trusted_registries": ["docker.io"]}],
+			"namespace_labels_fallback": ["registry.corp/Restricted"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithTrustedRegistriesConfigMap(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries_config_map": {"namespace": "kubewarden", "name": "trusted-registries", "key": "registries.txt"}}`, true},
+		{`{"trusted_registries": ["registry.corp"],
+			"trusted_registries_config_map": {"namespace": "kubewarden", "name": "trusted-registries", "key": "REGISTRIES_list"}}`, true},
+		{`{"trusted_registries_config_map": {"name": "trusted-registries", "key": "registries.txt"}}`, false},
+		{`{"trusted_registries_config_map": {"namespace": "kubewarden", "name": "Trusted", "key": "registries.txt"}}`, false},
+		{`{"trusted_registries_config_map": {"namespace": "kubewarden", "name": "trusted-registries", "key": ""}}`, false},
+		{`{"trusted_registries_config_map": {"namespace": "kubewarden", "name": "trusted-registries", "key": "a/b"}}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
+
+func TestValidateSettingsWithRequiredCredentials(t *testing.T) {
+	tests := []struct {
+		rawSettings string
+		expected    bool
+	}{
+		{`{"trusted_registries": ["registry.corp"], "requires_credentials": ["registry.corp", "registry.corp:5000", "index.docker.io"]}`, true},
+		{`{"trusted_registries": ["registry.corp"], "requires_credentials": ["registry.corp/team"]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "requires_credentials": ["*.corp"]}`, false},
+		{`{"trusted_registries": ["registry.corp"], "requires_credentials": ["registry..corp"]}`, false},
+	}
+
+	for _, test := range tests {
+		response := validateRawSettings(t, test.rawSettings)
+		if response.Valid != test.expected {
+			t.Errorf("Expected settings %s to be valid=%v, got %v (%v)",
+				test.rawSettings, test.expected, response.Valid, response.Message)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/oci"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/oci/verify_v2"
+)
+
+const pemPublicKeyType = "PUBLIC KEY"
+
+// KeylessIdentity is a keyless signer: the OIDC issuer and the subject of
+// the certificate used to sign. The subject is matched either exactly or,
+// with SubjectPrefix, as a URL prefix, which the host sanitizes by
+// appending "/" to protect against typosquatting.
+type KeylessIdentity struct {
+	Issuer        string `json:"issuer"`
+	Subject       string `json:"subject,omitempty"`
+	SubjectPrefix string `json:"subject_prefix,omitempty"`
+	// SubjectRegexp is rejected: the sigstore capabilities of the host only
+	// match subjects exactly or by prefix. It is decoded so that such
+	// settings fail instead of being silently ignored.
+	SubjectRegexp string `json:"subject_regexp,omitempty"`
+}
+
+func (i KeylessIdentity) validate() error {
+	if i.Issuer == "" {
+		return errors.New("no issuer provided")
+	}
+	if i.SubjectRegexp != "" {
+		return fmt.Errorf("identity of issuer '%s': subject_regexp is not supported by the host, use subject or subject_prefix", i.Issuer)
+	}
+	if (i.Subject == "") == (i.SubjectPrefix == "") {
+		return fmt.Errorf("identity of issuer '%s' must have exactly one of subject and subject_prefix", i.Issuer)
+	}
+	return nil
+}
+
+// validatePublicKeys checks that every key is a PEM encoded public key.
+func validatePublicKeys(keys []string) error {
+	for i, key := range keys {
+		block, rest := pem.Decode([]byte(key))
+		if block == nil || block.Type != pemPublicKeyType || strings.TrimSpace(string(rest)) != "" {
+			return fmt.Errorf("key %d is not a PEM encoded public key", i)
+		}
+	}
+	return nil
+}
+
+// signatureRequirements are the signers a registry rule requires. Every
+// public key and every keyless identity must have signed the image.
+type signatureRequirements struct {
+	keys           []string
+	keyless        []oci.KeylessInfo
+	keylessPrefix  []verify_v2.KeylessPrefixInfo
+	registryRuleID string
+}
+
+func (r *RegistryRule) signatureRequirements() (signatureRequirements, bool) {
+	requirements := signatureRequirements{
+		keys:           r.VerifyKeys,
+		registryRuleID: strings.Join(r.Registries, ", "),
+	}
+	for _, identity := range r.VerifyKeyless {
+		if identity.Subject != "" {
+			requirements.keyless = append(requirements.keyless, oci.KeylessInfo{Issuer: identity.Issuer, Subject: identity.Subject})
+		} else {
+			requirements.keylessPrefix = append(requirements.keylessPrefix,
+				verify_v2.KeylessPrefixInfo{Issuer: identity.Issuer, UrlPrefix: identity.SubjectPrefix})
+		}
+	}
+	required := len(requirements.keys) > 0 || len(requirements.keyless) > 0 || len(requirements.keylessPrefix) > 0
+	return requirements, required
+}
+
+// signatureVerifier verifies the sigstore signatures of images through the
+// host capabilities. Results, including failures, are cached for the
+// duration of a request, so that an image used by several containers is
+// verified once.
+type signatureVerifier struct {
+	host  *capabilities.Host
+	cache map[string]signatureVerification
+}
+
+type signatureVerification struct {
+	trusted bool
+	err     error
+}
+
+func newSignatureVerifier(h *capabilities.Host) *signatureVerifier {
+	return &signatureVerifier{host: h, cache: map[string]signatureVerification{}}
+}
+
+// verify reports whether the image is signed by every required signer.
+func (v *signatureVerifier) verify(image string, requirements signatureRequirements) (bool, error) {
+	key := requirements.registryRuleID + "|" + image
+	if cached, found := v.cache[key]; found {
+		return cached.trusted, cached.err
+	}
+	trusted, err := v.lookup(image, requirements)
+	v.cache[key] = signatureVerification{trusted: trusted, err: err}
+	return trusted, err
+}
+
+func (v *signatureVerifier) lookup(image string, requirements signatureRequirements) (bool, error) {
+	if v.host.Client == nil {
+		return false, errors.New("host capabilities are not available")
+	}
+
+	var checks []func() (oci.VerificationResponse, error)
+	if len(requirements.keys) > 0 {
+		checks = append(checks, func() (oci.VerificationResponse, error) {
+			return verify_v2.VerifyPubKeysImage(v.host, image, requirements.keys, nil)
+		})
+	}
+	if len(requirements.keyless) > 0 {
+		checks = append(checks, func() (oci.VerificationResponse, error) {
+			return verify_v2.VerifyKeylessExactMatch(v.host, image, requirements.keyless, nil)
+		})
+	}
+	if len(requirements.keylessPrefix) > 0 {
+		checks = append(checks, func() (oci.VerificationResponse, error) {
+			return verify_v2.VerifyKeylessPrefixMatch(v.host, image, requirements.keylessPrefix, nil)
+		})
+	}
+
+	for _, check := range checks {
+		response, err := check()
+		if err != nil {
+			return false, err
+		}
+		if !response.IsTrusted {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkSignatures returns why the image violates the signature requirements
+// of the registry rule matching it, if it does. Images whose signatures
+// cannot be verified are rejected.
+func (s *Settings) checkSignatures(ref imageReference, verifier *signatureVerifier) (string, bool) {
+	rule, found := s.registryRuleFor(ref)
+	if !found {
+		return "", false
+	}
+	requirements, required := rule.signatureRequirements()
+	if !required {
+		return "", false
+	}
+
+	trusted, err := verifier.verify(ref.String(), requirements)
+	if err != nil {
+		return fmt.Sprintf("is not verified: its signatures cannot be checked: %v", err), true
+	}
+	if !trusted {
+		return fmt.Sprintf("is unsigned or not signed by the signers required for '%s'", requirements.registryRuleID), true
+	}
+	return "", false
+}
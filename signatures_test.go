@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+)
+
+const testPublicKey = `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAGralukzi8fBbfzbINwamsOkQ8FOeJBSCMGuDn6gGf2M=
+-----END PUBLIC KEY-----`
+
+func TestValidateSignatures(t *testing.T) {
+	client := newFakeHost()
+	client.sign("SigstorePubKeyVerify", "registry.corp/app:1.0")
+	client.sign("SigstoreKeylessVerify", "ghcr.io/corp/app:1.0", "ghcr.io/corp/half:1.0")
+	client.sign("SigstoreKeylessPrefixVerify", "ghcr.io/corp/app:1.0")
+	client.errs["SigstorePubKeyVerify|registry.corp/down:1.0"] = errors.New("registry unreachable")
+
+	cases := []struct {
+		image           string
+		expectedMessage string
+	}{
+		{image: "registry.corp/app:1.0"},
+		{image: "ghcr.io/corp/app:1.0"},
+		{image: "ghcr.io/other/app:1.0"},
+		{
+			image:           "registry.corp/unsigned:1.0",
+			expectedMessage: "container 'container-0': image 'registry.corp/unsigned:1.0' is unsigned or not signed by the signers required for 'registry.corp'",
+		},
+		{
+			image:           "ghcr.io/corp/half:1.0",
+			expectedMessage: "container 'container-0': image 'ghcr.io/corp/half:1.0' is unsigned or not signed by the signers required for 'ghcr.io/corp'",
+		},
+		{
+			image:           "registry.corp/down:1.0",
+			expectedMessage: "container 'container-0': image 'registry.corp/down:1.0' is not verified: its signatures cannot be checked: registry unreachable",
+		},
+		{
+			image:           "docker.io/corp/app:1.0",
+			expectedMessage: "container 'container-0': image 'docker.io/corp/app:1.0' is not from a trusted registry",
+		},
+	}
+
+	for _, testCase := range cases {
+		useFakeHost(t, client)
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp", "ghcr.io"),
+			RegistryRules: []RegistryRule{
+				{Registries: []string{"registry.corp"}, VerifyKeys: []string{testPublicKey}},
+				{
+					Registries: []string{"ghcr.io/corp"},
+					VerifyKeyless: []KeylessIdentity{
+						{Issuer: "https://token.actions.githubusercontent.com", Subject: "https://github.com/corp/app/.github/workflows/release.yml@refs/heads/main"},
+						{Issuer: "https://token.actions.githubusercontent.com", SubjectPrefix: "https://github.com/corp"},
+					},
+				},
+			},
+		}
+		if err := settings.compile(); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+
+		response := validatePodImages(t, []string{testCase.image}, &settings)
+		if response.Accepted != (testCase.expectedMessage == "") {
+			t.Errorf("Image %s: unexpected accepted=%v (%v)", testCase.image, response.Accepted, response.Message)
+			continue
+		}
+		if !response.Accepted && *response.Message != testCase.expectedMessage {
+			t.Errorf("Expected message %q, got %q", testCase.expectedMessage, *response.Message)
+		}
+	}
+	for call := range client.calls {
+		if strings.HasSuffix(call, "|docker.io/corp/app:1.0") || strings.HasSuffix(call, "|ghcr.io/other/app:1.0") {
+			t.Errorf("Expected no verification of untrusted images or images without signers, got %v", client.calls)
+		}
+	}
+}
+
+func TestSignatureVerifierCachesResults(t *testing.T) {
+	client := newFakeHost()
+	client.sign("SigstorePubKeyVerify", "registry.corp/app:1.0")
+	verifier := newSignatureVerifier(&capabilities.Host{Client: client})
+	requirements := signatureRequirements{keys: []string{testPublicKey}, registryRuleID: "registry.corp"}
+
+	for range 3 {
+		if trusted, err := verifier.verify("registry.corp/app:1.0", requirements); !trusted || err != nil {
+			t.Errorf("Expected the image to be trusted, got %v (%v)", trusted, err)
+		}
+	}
+	if calls := client.calls["SigstorePubKeyVerify|registry.corp/app:1.0"]; calls != 1 {
+		t.Errorf("Expected a single host call, got %d", calls)
+	}
+	if _, err := newSignatureVerifier(&capabilities.Host{}).verify("registry.corp/app:1.0", requirements); err == nil {
+		t.Errorf("Expected an error without host client")
+	}
+}
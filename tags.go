@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// RegistryRule overrides the image policy for the registries and
+// repositories it selects. Rules are evaluated in order and the first one
+// matching the image wins; fields that are not set keep the global value.
+type RegistryRule struct {
+	// Registries are registry entries, in the same form as the trusted
+	// registries, selecting the images the rule applies to.
+	Registries []string `json:"registries"`
+	// DisallowedTags replaces the global disallowed tags. An empty list
+	// allows every tag.
+	DisallowedTags []string `json:"disallowed_tags,omitempty"`
+	// RejectImplicitTag replaces the global reject_implicit_tag flag.
+	RejectImplicitTag *bool `json:"reject_implicit_tag,omitempty"`
+	// RequireDigest replaces the require_digest flag of the settings and of
+	// the namespace rule.
+	RequireDigest *bool `json:"require_digest,omitempty"`
+	// AllowedTagPatterns are regular expressions matched against the whole
+	// tag. AllowedVersions is a semantic version range, such as
+	// ">=2.0.0 <3.0.0", tags being parsed as versions with an optional
+	// leading "v". When either is set, the tag must match a pattern or be a
+	// version within the range.
+	AllowedTagPatterns []string `json:"allowed_tag_patterns,omitempty"`
+	AllowedVersions    string   `json:"allowed_versions,omitempty"`
+	// VerifyKeys are PEM encoded cosign public keys and VerifyKeyless are
+	// keyless identities that must all have signed the images, as verified
+	// by the sigstore capabilities of the host.
+	VerifyKeys    []string          `json:"verify_keys,omitempty"`
+	VerifyKeyless []KeylessIdentity `json:"verify_keyless,omitempty"`
+
+	patterns    []registryPattern
+	tagPatterns []*regexp.Regexp
+	versions    *versionRange
+}
+
+// compile validates the rule and prepares its registries for matching.
+func (r *RegistryRule) compile() error {
+	if len(r.Registries) == 0 {
+		return errors.New("no registries provided")
+	}
+	var err error
+	r.patterns, err = compileRegistryPatterns(r.Registries)
+	if err != nil {
+		return fmt.Errorf("registries: %w", err)
+	}
+	if err = validateTags(r.DisallowedTags); err != nil {
+		return fmt.Errorf("disallowed_tags: %w", err)
+	}
+	r.tagPatterns, err = compileTagPatterns(r.AllowedTagPatterns)
+	if err != nil {
+		return fmt.Errorf("allowed_tag_patterns: %w", err)
+	}
+	if r.AllowedVersions != "" {
+		r.versions, err = parseVersionRange(r.AllowedVersions)
+		if err != nil {
+			return fmt.Errorf("allowed_versions: %w", err)
+		}
+	}
+	if err = validatePublicKeys(r.VerifyKeys); err != nil {
+		return fmt.Errorf("verify_keys: %w", err)
+	}
+	for _, identity := range r.VerifyKeyless {
+		if err = identity.validate(); err != nil {
+			return fmt.Errorf("verify_keyless: %w", err)
+		}
+	}
+	return nil
+}
+
+// compileTagPatterns compiles the patterns so that they match the whole tag.
+func compileTagPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w (only RE2 syntax is supported, without lookarounds or backreferences)", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// allowsTag reports whether the tag satisfies the tag constraints of the
+// rule. Every tag is allowed when the rule has no constraints.
+func (r *RegistryRule) allowsTag(tag string) bool {
+	if !r.hasTagConstraints() {
+		return true
+	}
+	for _, re := range r.tagPatterns {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	if r.versions != nil {
+		if v, err := parseVersion(tag); err == nil && r.versions.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTagConstraints reports whether the rule restricts the tags of the
+// images it selects.
+func (r *RegistryRule) hasTagConstraints() bool {
+	return len(r.tagPatterns) > 0 || r.versions != nil
+}
+
+// describeTagConstraints lists the tag constraints of the rule for
+// rejection messages.
+func (r *RegistryRule) describeTagConstraints() string {
+	var constraints []string
+	if len(r.AllowedTagPatterns) > 0 {
+		constraints = append(constraints, fmt.Sprintf("match %s", strings.Join(quoteAll(r.AllowedTagPatterns), " or ")))
+	}
+	if r.AllowedVersions != "" {
+		constraints = append(constraints, fmt.Sprintf("be a version within '%s'", r.AllowedVersions))
+	}
+	return strings.Join(constraints, " or ")
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, "'"+value+"'")
+	}
+	return quoted
+}
+
+// tagPolicy decides which tags trusted images may use and whether they
+// must be pinned by digest.
+type tagPolicy struct {
+	disallowedTags    mapset.Set[string]
+	rejectImplicitTag bool
+	requireDigest     bool
+	// rule is the registry rule whose tag constraints apply, if any.
+	rule *RegistryRule
+}
+
+// tagPolicyFor returns the tag policy of the image: the global one, with the
+// overrides of the namespace rule and of the first registry rule matching
+// the image applied, in that order.
+func (s *Settings) tagPolicyFor(ref imageReference, namespaceRule *NamespaceRule) tagPolicy {
+	policy := tagPolicy{
+		disallowedTags:    s.DisallowedTags,
+		rejectImplicitTag: s.RejectImplicitTag,
+		requireDigest:     s.RequireDigest,
+	}
+	if namespaceRule != nil && namespaceRule.RequireDigest != nil {
+		policy.requireDigest = *namespaceRule.RequireDigest
+	}
+	rule, found := s.registryRuleFor(ref)
+	if !found {
+		return policy
+	}
+	if rule.DisallowedTags != nil {
+		policy.disallowedTags = mapset.NewThreadUnsafeSet[string](rule.DisallowedTags...)
+	}
+	if rule.RejectImplicitTag != nil {
+		policy.rejectImplicitTag = *rule.RejectImplicitTag
+	}
+	if rule.RequireDigest != nil {
+		policy.requireDigest = *rule.RequireDigest
+	}
+	policy.rule = rule
+	return policy
+}
+
+// registryRuleFor returns the first registry rule matching the image.
+func (s *Settings) registryRuleFor(ref imageReference) (*RegistryRule, bool) {
+	for i := range s.RegistryRules {
+		if _, found := findMatchingPattern(ref, s.RegistryRules[i].patterns); found {
+			return &s.RegistryRules[i], true
+		}
+	}
+	return nil, false
+}
+
+// check returns why the image violates the tag policy, if it does. raw is
+// the image reference as written in the pod spec and ref its normalized
+// form. Images pinned by digest are not checked against disallowed and
+// implicit tags, since the runtime ignores their tag. The tag constraints of
+// registry rules still apply to them, so adding a digest cannot bypass
+// them, and images pinned by digest alone are rejected under such rules,
+// since their version cannot be told.
+func (p tagPolicy) check(raw, ref imageReference) (string, bool) {
+	if ref.Digest == "" {
+		if p.requireDigest {
+			return "is not pinned by digest", true
+		}
+		if p.rejectImplicitTag && raw.Tag == "" {
+			return fmt.Sprintf("has no tag and defaults to '%s'", defaultTag), true
+		}
+		if p.disallowedTags != nil && p.disallowedTags.Contains(ref.Tag) {
+			return fmt.Sprintf("uses the disallowed tag '%s'", ref.Tag), true
+		}
+	}
+	if p.rule == nil || !p.rule.hasTagConstraints() {
+		return "", false
+	}
+	if ref.Tag == "" {
+		return fmt.Sprintf("is pinned by digest without a tag, which must %s", p.rule.describeTagConstraints()), true
+	}
+	if !p.rule.allowsTag(ref.Tag) {
+		return fmt.Sprintf("uses the tag '%s', which must %s", ref.Tag, p.rule.describeTagConstraints()), true
+	}
+	return "", false
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
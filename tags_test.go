@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+func TestTagPolicyCheck(t *testing.T) {
+	policy := tagPolicy{
+		disallowedTags:    mapset.NewThreadUnsafeSet[string]("latest", "dev"),
+		rejectImplicitTag: true,
+	}
+
+	cases := []struct {
+		image          string
+		expectedReason string
+	}{
+		{"registry.corp/app:1.0", ""},
+		{"registry.corp/app:latest", "uses the disallowed tag 'latest'"},
+		{"registry.corp/app:dev", "uses the disallowed tag 'dev'"},
+		{"registry.corp/app:Latest", ""},
+		{"registry.corp/app", "has no tag and defaults to 'latest'"},
+		{"registry.corp/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ""},
+		{"registry.corp/app:latest@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ""},
+	}
+
+	for _, testCase := range cases {
+		raw, err := parseImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		reason, _ := policy.check(raw, raw.Normalize())
+		if reason != testCase.expectedReason {
+			t.Errorf("Image %s: expected reason %q, got %q", testCase.image, testCase.expectedReason, reason)
+		}
+	}
+}
+
+func TestTagPolicyFor(t *testing.T) {
+	allowImplicitTag := false
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp", "quay.io"),
+		DisallowedTags:    mapset.NewThreadUnsafeSet[string]("latest"),
+		RejectImplicitTag: true,
+		RegistryRules: []RegistryRule{
+			{Registries: []string{"registry.corp/sandbox"}, DisallowedTags: []string{}, RejectImplicitTag: &allowImplicitTag},
+			{Registries: []string{"registry.corp"}, DisallowedTags: []string{"latest", "main"}},
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		image          string
+		expectedReason string
+	}{
+		{"registry.corp/sandbox/app:latest", ""},
+		{"registry.corp/sandbox/app", ""},
+		{"registry.corp/app:main", "uses the disallowed tag 'main'"},
+		{"registry.corp/app", "has no tag and defaults to 'latest'"},
+		{"quay.io/app:main", ""},
+		{"quay.io/app:latest", "uses the disallowed tag 'latest'"},
+	}
+
+	for _, testCase := range cases {
+		raw, err := parseImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		ref := raw.Normalize()
+		reason, _ := settings.tagPolicyFor(ref, nil).check(raw, ref)
+		if reason != testCase.expectedReason {
+			t.Errorf("Image %s: expected reason %q, got %q", testCase.image, testCase.expectedReason, reason)
+		}
+	}
+}
+
+func TestRequireDigestOverrides(t *testing.T) {
+	required, notRequired := true, false
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		NamespaceRules: []NamespaceRule{
+			{Namespaces: []string{"prod-*"}, TrustedRegistries: []string{"registry.corp"}, RequireDigest: &required},
+		},
+		RegistryRules: []RegistryRule{
+			{Registries: []string{"registry.corp/tools"}, RequireDigest: &notRequired},
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		namespace             string
+		image                 string
+		expectedRequireDigest bool
+	}{
+		{"default", "registry.corp/app:1.0", false},
+		{"prod-eu", "registry.corp/app:1.0", true},
+		{"prod-eu", "registry.corp/tools/debug:1.0", false},
+	}
+
+	for _, testCase := range cases {
+		ref, err := parseNormalizedImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		_, namespaceRule := settings.trustRulesFor(requestContext{Namespace: testCase.namespace})
+		policy := settings.tagPolicyFor(ref, namespaceRule)
+		if policy.requireDigest != testCase.expectedRequireDigest {
+			t.Errorf("Image %s in namespace %s: expected requireDigest=%v, got %v", testCase.image,
+				testCase.namespace, testCase.expectedRequireDigest, policy.requireDigest)
+		}
+	}
+}
+
+func TestTagConstraints(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		RegistryRules: []RegistryRule{
+			{
+				Registries:         []string{"registry.corp/base/*"},
+				AllowedTagPatterns: []string{`v\d+\.\d+\.\d+`},
+				AllowedVersions:    ">=2.0.0 <3.0.0",
+			},
+			{Registries: []string{"registry.corp/apps"}, AllowedVersions: ">=1.0.0"},
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Unexpected invalid settings: %+v", err)
+	}
+
+	cases := []struct {
+		image          string
+		expectedReason string
+	}{
+		{"registry.corp/base/alpine:2.3.1", ""},
+		{"registry.corp/base/alpine:v1.0.0", ""},
+		{"registry.corp/base/alpine:v1.0.0-debug", "uses the tag 'v1.0.0-debug', which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/base/alpine:1.5.0", "uses the tag '1.5.0', which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/base/alpine", "uses the tag 'latest', which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/base/alpine:3.0.0-rc.1", "uses the tag '3.0.0-rc.1', which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/apps/api:1.2.0", ""},
+		{"registry.corp/apps/api:main", "uses the tag 'main', which must be a version within '>=1.0.0'"},
+		{"registry.corp/other:main", ""},
+		// A digest does not bypass the tag constraints
+		{"registry.corp/base/x:2.1.0@" + approvedDigest, ""},
+		{"registry.corp/base/x:1.0.0@" + approvedDigest, "uses the tag '1.0.0', which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/base/x@" + approvedDigest, "is pinned by digest without a tag, which must match " +
+			`'v\d+\.\d+\.\d+' or be a version within '>=2.0.0 <3.0.0'`},
+		{"registry.corp/other@" + approvedDigest, ""},
+	}
+
+	for _, testCase := range cases {
+		raw, err := parseImageReference(testCase.image)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", testCase.image, err)
+			continue
+		}
+		ref := raw.Normalize()
+		reason, _ := settings.tagPolicyFor(ref, nil).check(raw, ref)
+		if reason != testCase.expectedReason {
+			t.Errorf("Image %s: expected reason %q, got %q", testCase.image, testCase.expectedReason, reason)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	templateOpen  = "{{"
+	templateClose = "}}"
+
+	variableNamespace         = "namespace"
+	variableServiceAccount    = "serviceAccount"
+	variableLabelsPrefix      = "labels."
+	variableAnnotationsPrefix = "annotations."
+
+	defaultServiceAccount = "default"
+)
+
+// requestContext holds the fields of the admission request that trusted
+// registry templates can refer to.
+type requestContext struct {
+	Namespace      string
+	ServiceAccount string
+	Labels         map[string]string
+	Annotations    map[string]string
+	// NamespaceLabels are the labels of the namespace of the request, only
+	// looked up when namespace rules select namespaces by label.
+	NamespaceLabels map[string]string
+	// PullSecrets are the names of the pull secrets of the pod spec, used by
+	// the credential check.
+	PullSecrets []string
+	// PreviousImages are the images of the containers of the old object of
+	// UPDATE requests, keyed by container. Images an update leaves unchanged
+	// were admitted before and are not evaluated again, so that updates of
+	// running pods are not blocked by later changes of the settings.
+	PreviousImages map[string]string
+
+	namespaceLabelsUnavailable bool
+}
+
+// newRequestContext extracts the template variables from the validation
+// request. Labels and annotations are read from the pod metadata, which is
+// the pod template of workloads, so that a workload is evaluated like the
+// pods its controller creates.
+func newRequestContext(validationRequest, podMetadata, podSpec gjson.Result) requestContext {
+	ctx := requestContext{
+		Namespace:      requestNamespace(validationRequest),
+		ServiceAccount: podSpec.Get("serviceAccountName").String(),
+		Labels:         stringMap(podMetadata.Get("labels")),
+		Annotations:    stringMap(podMetadata.Get("annotations")),
+		PullSecrets:    podSpecPullSecrets(podSpec),
+	}
+	if ctx.ServiceAccount == "" {
+		ctx.ServiceAccount = defaultServiceAccount
+	}
+	return ctx
+}
+
+func stringMap(result gjson.Result) map[string]string {
+	values := map[string]string{}
+	result.ForEach(func(key, value gjson.Result) bool {
+		values[key.String()] = value.String()
+		return true
+	})
+	return values
+}
+
+// lookup returns the value of a template variable for the request.
+func (c requestContext) lookup(variable string) (string, bool) {
+	switch {
+	case variable == variableNamespace:
+		return c.Namespace, c.Namespace != ""
+	case variable == variableServiceAccount:
+		return c.ServiceAccount, c.ServiceAccount != ""
+	case strings.HasPrefix(variable, variableLabelsPrefix):
+		value, found := c.Labels[strings.TrimPrefix(variable, variableLabelsPrefix)]
+		return value, found && value != ""
+	case strings.HasPrefix(variable, variableAnnotationsPrefix):
+		value, found := c.Annotations[strings.TrimPrefix(variable, variableAnnotationsPrefix)]
+		return value, found && value != ""
+	}
+	return "", false
+}
+
+// registryTemplate is a trusted registry entry with variables, such as
+// "registry.corp/{{namespace}}/", that are resolved for every request.
+type registryTemplate struct {
+	entry string
+	// parts alternates literal text and variable names, starting with
+	// literal text.
+	parts []string
+}
+
+func isRegistryTemplate(entry string) bool {
+	return strings.Contains(entry, templateOpen)
+}
+
+// compileRegistryTemplate parses the variables of the entry, rejecting
+// unknown ones, and checks that the entry is a valid registry pattern once
+// the variables are resolved.
+func compileRegistryTemplate(entry string) (registryTemplate, error) {
+	template := registryTemplate{entry: entry}
+
+	rest := entry
+	for {
+		start := strings.Index(rest, templateOpen)
+		if start < 0 {
+			template.parts = append(template.parts, rest)
+			break
+		}
+		end := strings.Index(rest[start:], templateClose)
+		if end < 0 {
+			return registryTemplate{}, fmt.Errorf("unterminated variable in '%s'", entry)
+		}
+		variable := strings.TrimSpace(rest[start+len(templateOpen) : start+end])
+		if err := validateTemplateVariable(variable); err != nil {
+			return registryTemplate{}, err
+		}
+		template.parts = append(template.parts, rest[:start], variable)
+		rest = rest[start+end+len(templateClose):]
+	}
+	for i := 0; i < len(template.parts); i += 2 {
+		if strings.Contains(template.parts[i], templateClose) {
+			return registryTemplate{}, fmt.Errorf("unexpected '%s' in '%s'", templateClose, entry)
+		}
+	}
+
+	// Any valid substitution must produce a valid pattern, so checking a
+	// placeholder value catches misplaced variables.
+	if _, err := compileRegistryPattern(template.expand(func(string) string { return "x" })); err != nil {
+		return registryTemplate{}, err
+	}
+
+	return template, nil
+}
+
+func validateTemplateVariable(variable string) error {
+	switch {
+	case variable == variableNamespace, variable == variableServiceAccount:
+		return nil
+	case strings.HasPrefix(variable, variableLabelsPrefix) && len(variable) > len(variableLabelsPrefix):
+		return nil
+	case strings.HasPrefix(variable, variableAnnotationsPrefix) && len(variable) > len(variableAnnotationsPrefix):
+		return nil
+	case variable == "":
+		return errors.New("empty variable")
+	}
+	return fmt.Errorf("unknown variable '%s', supported variables are: %s, %s, %s<key>, %s<key>",
+		variable, variableNamespace, variableServiceAccount, variableLabelsPrefix, variableAnnotationsPrefix)
+}
+
+func (t registryTemplate) expand(value func(variable string) string) string {
+	var builder strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			builder.WriteString(part)
+		} else {
+			builder.WriteString(value(part))
+		}
+	}
+	return builder.String()
+}
+
+// resolve substitutes the variables with the values of the request. Values
+// must be valid repository path components, so that a label or annotation
+// cannot inject path separators or wildcards into the pattern. Entries with
+// missing or unsafe values do not match anything.
+func (t registryTemplate) resolve(ctx requestContext) (registryPattern, error) {
+	var resolveErr error
+	entry := t.expand(func(variable string) string {
+		value, found := ctx.lookup(variable)
+		if !found {
+			resolveErr = errors.Join(resolveErr, fmt.Errorf("variable '%s' has no value", variable))
+			return ""
+		}
+		if !isPathComponent(value) {
+			resolveErr = errors.Join(resolveErr, fmt.Errorf("variable '%s' has unsafe value '%s'", variable, value))
+			return ""
+		}
+		return value
+	})
+	if resolveErr != nil {
+		return registryPattern{}, resolveErr
+	}
+
+	pattern, err := compileRegistryPattern(entry)
+	if err != nil {
+		return registryPattern{}, err
+	}
+	pattern.entry = t.entry
+	return pattern, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCompileRegistryTemplate(t *testing.T) {
+	valid := []string{
+		"registry.corp/{{namespace}}/",
+		"registry.corp/{{ labels.team }}",
+		"registry.corp/teams/{{annotations.example.com/owner}}/{{serviceAccount}}",
+		"{{namespace}}.registry.corp/app",
+		"registry.corp/team-{{labels.team}}",
+	}
+	for _, entry := range valid {
+		if _, err := compileRegistryTemplate(entry); err != nil {
+			t.Errorf("Unexpected error compiling %q: %+v", entry, err)
+		}
+	}
+
+	invalid := []string{
+		"registry.corp/{{cluster}}",
+		"registry.corp/{{labels.}}",
+		"registry.corp/{{}}",
+		"registry.corp/{{namespace",
+		"registry.corp/{{namespace}}}}",
+		"registry.corp/{{namespace}}//app",
+	}
+	for _, entry := range invalid {
+		if _, err := compileRegistryTemplate(entry); err == nil {
+			t.Errorf("Expected an error compiling %q", entry)
+		}
+	}
+}
+
+func TestResolveRegistryTemplate(t *testing.T) {
+	ctx := requestContext{
+		Namespace:      "team-a",
+		ServiceAccount: "builder",
+		Labels:         map[string]string{"team": "payments", "unsafe": "a/b", "wildcard": "*"},
+		Annotations:    map[string]string{"example.com/owner": "alice"},
+	}
+
+	cases := []struct {
+		entry       string
+		name        string
+		expected    bool
+		expectedErr bool
+	}{
+		{entry: "registry.corp/{{namespace}}/", name: "registry.corp/team-a/app", expected: true},
+		{entry: "registry.corp/{{namespace}}/", name: "registry.corp/team-b/app", expected: false},
+		{entry: "registry.corp/{{labels.team}}", name: "registry.corp/payments/api", expected: true},
+		{entry: "registry.corp/{{annotations.example.com/owner}}", name: "registry.corp/alice/app", expected: true},
+		{entry: "registry.corp/{{serviceAccount}}", name: "registry.corp/builder/app", expected: true},
+		// Missing values and values that would change the pattern structure
+		{entry: "registry.corp/{{labels.missing}}", expectedErr: true},
+		{entry: "registry.corp/{{labels.unsafe}}", expectedErr: true},
+		{entry: "registry.corp/{{labels.wildcard}}", expectedErr: true},
+	}
+
+	for _, testCase := range cases {
+		template, err := compileRegistryTemplate(testCase.entry)
+		if err != nil {
+			t.Fatalf("Unexpected error compiling %q: %+v", testCase.entry, err)
+		}
+
+		pattern, err := template.resolve(ctx)
+		if testCase.expectedErr {
+			if err == nil {
+				t.Errorf("Expected an error resolving %q", testCase.entry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error resolving %q: %+v", testCase.entry, err)
+			continue
+		}
+		if got := pattern.Match(testCase.name); got != testCase.expected {
+			t.Errorf("Template %q matching %q: expected %v, got %v", testCase.entry, testCase.name, testCase.expected, got)
+		}
+	}
+}
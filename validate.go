@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strings"
 
-	mapset "github.com/deckarep/golang-set/v2"
 	kubewarden "github.com/kubewarden/policy-sdk-go"
 	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
 	"github.com/tidwall/gjson"
@@ -29,53 +28,197 @@ func validate(payload []byte) ([]byte, error) {
 			kubewarden.Code(httpBadRequestStatusCode))
 	}
 
-	// 获取容器列表
-	containers := getContainers(validationRequest.Get("request.object.spec.containers"))
-	if validationErr := validateContainers(containers, settings.TrustedRegistries); validationErr != nil {
+	if exemption, exempted := settings.findExemption(validationRequest); exempted {
+		logExemption(exemption)
+		return kubewarden.AcceptRequest()
+	}
+
+	kind := validationRequest.Get("request.kind.kind").String()
+	podSpecPath, found := podSpecPath(kind)
+	if !found {
 		return kubewarden.RejectRequest(
-			kubewarden.Message(validationErr.Error()),
-			kubewarden.NoCode)
+			kubewarden.Message(fmt.Sprintf("object kind '%s' is not supported, it should be one of: %s",
+				kind, strings.Join(supportedKinds(), ", "))),
+			kubewarden.Code(httpBadRequestStatusCode))
+	}
+
+	podSpec := validationRequest.Get(podSpecPath)
+	metadataPath, _ := podMetadataPath(kind)
+	ctx := newRequestContext(validationRequest, validationRequest.Get(metadataPath), podSpec)
+	ctx.PreviousImages = previousImages(validationRequest, podSpecPath)
+	if err = settings.loadNamespaceLabels(&ctx); err != nil {
+		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.NoCode)
 	}
+	settings.loadConfigMapRegistries(ctx)
 
-	// 获取初始化容器列表
-	initContainers := getContainers(validationRequest.Get("request.object.spec.initContainers"))
-	if validationErr := validateContainers(initContainers, settings.TrustedRegistries); validationErr != nil {
+	if settings.Mutate && canMutate(kind, validationRequest.Get("request.operation").String()) {
+		return mutate(payload, kind, podSpec, &settings, ctx)
+	}
+	return validatePodSpec(podSpec, &settings, ctx)
+}
+
+// validatePodSpec accepts the request when every container of the pod spec
+// passes the checks, and rejects it with all the violations otherwise.
+func validatePodSpec(podSpec gjson.Result, settings *Settings, ctx requestContext) ([]byte, error) {
+	// 获取容器列表
+	containers := getContainers(podSpec)
+	if violations := validateContainers(containers, settings, ctx); len(violations) > 0 {
 		return kubewarden.RejectRequest(
-			kubewarden.Message(validationErr.Error()),
+			kubewarden.Message(formatViolations(violations)),
 			kubewarden.NoCode)
 	}
 
 	return kubewarden.AcceptRequest()
 }
 
-func getContainers(result gjson.Result) []string {
-	var images []string
-	result.ForEach(func(_, value gjson.Result) bool {
-		if img := value.Get("image").String(); img != "" {
-			images = append(images, img)
-		}
-		return true
-	})
-	return images
+// podSpecPath returns where the pod spec of an object of the given kind is
+// found inside of the validation request. Workload resources are checked
+// through their pod template, so untrusted images are rejected when the
+// workload is created instead of when its controller creates the pods.
+// Requests without a kind are evaluated as pods. Clusters older than 1.22
+// send the pods/ephemeralcontainers subresource as an EphemeralContainers
+// object, which holds the containers at its top level.
+func podSpecPath(kind string) (string, bool) {
+	switch kind {
+	case "", "Pod":
+		return "request.object.spec", true
+	case "EphemeralContainers":
+		return "request.object", true
+	case "Deployment", "ReplicaSet", "StatefulSet", "DaemonSet", "Job", "ReplicationController":
+		return "request.object.spec.template.spec", true
+	case "CronJob":
+		return "request.object.spec.jobTemplate.spec.template.spec", true
+	default:
+		return "", false
+	}
 }
 
-func validateContainers(containers []string, trustedRegistries mapset.Set[string]) error {
-	for _, image := range containers {
-		logger.Debug(fmt.Sprintf("Checking container image: %s", image))
-		if !isImageTrusted(image, trustedRegistries) {
-			logger.Error(fmt.Sprintf("Container image %s is not from a trusted registry", image))
-			return fmt.Errorf("image '%s' is not from a trusted registry", image)
-		}
-		logger.Debug(fmt.Sprintf("Container image %s is from a trusted registry", image))
+// podMetadataPath returns where the metadata of the pods of an object of the
+// given kind is found inside of the validation request. Pods created by
+// workload controllers only carry the metadata of the pod template.
+func podMetadataPath(kind string) (string, bool) {
+	switch kind {
+	case "", "Pod", "EphemeralContainers":
+		return "request.object.metadata", true
+	case "Deployment", "ReplicaSet", "StatefulSet", "DaemonSet", "Job", "ReplicationController":
+		return "request.object.spec.template.metadata", true
+	case "CronJob":
+		return "request.object.spec.jobTemplate.spec.template.metadata", true
+	default:
+		return "", false
 	}
-	return nil
 }
 
-func isImageTrusted(image string, trustedRegistries mapset.Set[string]) bool {
-	for _, registry := range trustedRegistries.ToSlice() {
-		if strings.HasPrefix(image, registry) {
-			return true
+func supportedKinds() []string {
+	return []string{"Pod", "Deployment", "ReplicaSet", "StatefulSet", "DaemonSet", "Job", "CronJob", "ReplicationController", "EphemeralContainers"}
+}
+
+// validateContainers evaluates every container and returns all the
+// violations found, so they can be reported at once.
+func validateContainers(containers []container, settings *Settings, ctx requestContext) []violation {
+	rules, namespaceRule := settings.trustRulesFor(ctx)
+	untrustedReason := "is not from a trusted registry"
+	if namespaceRule != nil {
+		logger.Debug(fmt.Sprintf("Namespace %s is selected by namespace rule %v %v", ctx.Namespace, namespaceRule.Namespaces, namespaceRule.NamespaceLabels))
+		untrustedReason = fmt.Sprintf("is not from a registry trusted in namespace '%s'", ctx.Namespace)
+	}
+	rules = rules.resolve(ctx)
+	allowlist, allowlisted := settings.digestAllowlistFor(ctx.Namespace)
+	verifier := newSignatureVerifier(&host)
+	credentials := newCredentialResolver(&host, ctx)
+
+	var violations []violation
+	// hasCredentials runs the credential check, which applies to every image
+	// about to be accepted, whichever check admitted it.
+	hasCredentials := func(c container, ref imageReference) bool {
+		reason, violated := settings.checkCredentials(ref, credentials)
+		if violated {
+			logger.Error(fmt.Sprintf("Image %s (%s) of %s %s", c.Image, ref, c, reason))
+			violations = append(violations, violation{
+				Container: c,
+				Reference: ref.String(),
+				Reason:    reason,
+			})
+		}
+		return !violated
+	}
+	for _, c := range containers {
+		if previous, found := ctx.PreviousImages[c.key()]; found && previous == c.Image {
+			logger.Debug(fmt.Sprintf("Image %s of %s is not changed by the update", c.Image, c))
+			continue
+		}
+		logger.Debug(fmt.Sprintf("Checking %s image: %s", c, c.Image))
+		raw, err := parseImageReference(c.Image)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Image %s of %s is not a valid image reference: %v", c.Image, c, err))
+			violations = append(violations, violation{
+				Container: c,
+				Reason:    fmt.Sprintf("is not a valid image reference: %v", err),
+			})
+			continue
+		}
+		ref := raw.Normalize()
+		if blocked, found := findMatchingPattern(ref, settings.blockedPatterns); found {
+			logger.Error(fmt.Sprintf("Image %s (%s) of %s is blocked by %s", c.Image, ref, c, blocked))
+			violations = append(violations, violation{
+				Container: c,
+				Reference: ref.String(),
+				Reason:    fmt.Sprintf("is blocked by '%s'", blocked),
+			})
+			continue
+		}
+		if allowlisted {
+			if !allowlist.allows(ref) {
+				logger.Error(fmt.Sprintf("Image %s (%s) of %s is not in the digest allowlist", c.Image, ref, c))
+				violations = append(violations, violation{
+					Container: c,
+					Reference: ref.String(),
+					Reason:    fmt.Sprintf("is not in the digest allowlist of namespace '%s'", ctx.Namespace),
+				})
+				continue
+			}
+			if hasCredentials(c, ref) {
+				logger.Debug(fmt.Sprintf("Image %s of %s is in the digest allowlist", c.Image, c))
+			}
+			continue
+		}
+		if !rules.trusts(ref) {
+			if exception, found := settings.findImageException(ref, ctx.Namespace); found {
+				if hasCredentials(c, ref) {
+					logImageException(c, ref, exception)
+				}
+				continue
+			}
+			logger.Error(fmt.Sprintf("Image %s (%s) of %s is not from a trusted registry", c.Image, ref, c))
+			violations = append(violations, violation{
+				Container: c,
+				Reference: ref.String(),
+				Reason:    untrustedReason,
+			})
+			continue
+		}
+		if reason, violated := settings.tagPolicyFor(ref, namespaceRule).check(raw, ref); violated {
+			logger.Error(fmt.Sprintf("Image %s (%s) of %s %s", c.Image, ref, c, reason))
+			violations = append(violations, violation{
+				Container: c,
+				Reference: ref.String(),
+				Reason:    reason,
+			})
+			continue
+		}
+		// Signatures are verified last, since it needs host callbacks.
+		if reason, violated := settings.checkSignatures(ref, verifier); violated {
+			logger.Error(fmt.Sprintf("Image %s (%s) of %s %s", c.Image, ref, c, reason))
+			violations = append(violations, violation{
+				Container: c,
+				Reference: ref.String(),
+				Reason:    reason,
+			})
+			continue
+		}
+		if hasCredentials(c, ref) {
+			logger.Debug(fmt.Sprintf("Image %s of %s is from a trusted registry", c.Image, c))
 		}
 	}
-	return false
+	return violations
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	mapset "github.com/deckarep/golang-set/v2"
@@ -68,8 +69,8 @@ func TestIsImageTrusted(t *testing.T) {
 			// ➅
 			// Pod has containers, all images are from trusted registries with SHA256 -> should be accepted
 			podImages: []string{
-				"quay.io/some/image@sha256:1234567890abcdef",
-				"docker.io/library/another/image@sha256:1234567890abcdef",
+				"quay.io/some/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"docker.io/library/another/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			},
 			trustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "docker.io/library"),
 			expectedIsValid:   true,
@@ -78,12 +79,91 @@ func TestIsImageTrusted(t *testing.T) {
 			// ➆
 			// Pod has containers, one image is not from a trusted registry with SHA256 -> should be rejected
 			podImages: []string{
-				"quay.io/some/image@sha256:1234567890abcdef",
-				"gcr.io/some/image@sha256:1234567890abcdef",
+				"quay.io/some/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"gcr.io/some/image@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			},
 			trustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io", "docker.io/library"),
 			expectedIsValid:   false,
 		},
+		{
+			// ➇
+			// Image registry only shares a string prefix with a trusted registry -> should be rejected
+			podImages: []string{
+				"quay.io.attacker.com/evil",
+				"quay.ioevil/x",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+			expectedIsValid:   false,
+		},
+		{
+			// ➈
+			// Image repository only shares a string prefix with a trusted path -> should be rejected
+			podImages: []string{
+				"docker.io/libraryevil/image",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("docker.io/library"),
+			expectedIsValid:   false,
+		},
+		{
+			// ➉
+			// Image is not a valid reference -> should be rejected
+			podImages: []string{
+				"quay.io/Some/Image",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+			expectedIsValid:   false,
+		},
+		{
+			// ⑪
+			// Pod uses Docker Hub short names, trusted registry is fully qualified -> should be accepted
+			podImages: []string{
+				"nginx:1.25",
+				"library/nginx",
+				"docker.io/nginx",
+				"index.docker.io/library/nginx:latest",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("docker.io/library"),
+			expectedIsValid:   true,
+		},
+		{
+			// ⑫
+			// Trusted registry uses the index.docker.io alias -> should be accepted
+			podImages: []string{
+				"nginx",
+				"someuser/app:1.0",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("index.docker.io"),
+			expectedIsValid:   true,
+		},
+		{
+			// ⑬
+			// Docker Hub user image is not an official library image -> should be rejected
+			podImages: []string{
+				"someuser/nginx",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("docker.io/library"),
+			expectedIsValid:   false,
+		},
+		{
+			// ⑭
+			// Images from regional registries and team paths matched by globs -> should be accepted
+			podImages: []string{
+				"eu-1.registry.corp/app:1.0",
+				"us-2.registry.corp/app:1.0",
+				"registry.corp/team-a/app:1.0",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("*.registry.corp", "registry.corp/team-*/"),
+			expectedIsValid:   true,
+		},
+		{
+			// ⑮
+			// Image path not matched by the glob -> should be rejected
+			podImages: []string{
+				"registry.corp/shared/app:1.0",
+			},
+			trustedRegistries: mapset.NewThreadUnsafeSet[string]("*.registry.corp", "registry.corp/team-*/"),
+			expectedIsValid:   false,
+		},
 	}
 
 	for _, testCase := range cases {
@@ -91,46 +171,623 @@ func TestIsImageTrusted(t *testing.T) {
 			TrustedRegistries: testCase.trustedRegistries,
 		}
 
-		pod := corev1.Pod{
-			Metadata: &metav1.ObjectMeta{
-				Name:      "test-pod",
-				Namespace: "default",
+		response := validatePodImages(t, testCase.podImages, &settings)
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection: msg %s - code %d with pod images: %v, trusted registries: %v",
+				*response.Message, *response.Code, testCase.podImages, testCase.trustedRegistries)
+		}
+
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance with pod images: %v, trusted registries: %v",
+				testCase.podImages, testCase.trustedRegistries)
+		}
+	}
+}
+
+func TestBlockedImages(t *testing.T) {
+	cases := []struct {
+		podImages       []string
+		blocked         mapset.Set[string]
+		expectedIsValid bool
+	}{
+		{
+			// Blocked repository inside of a trusted registry -> should be rejected
+			podImages:       []string{"docker.io/someuser/app:1.0"},
+			blocked:         mapset.NewThreadUnsafeSet[string]("docker.io/someuser"),
+			expectedIsValid: false,
+		},
+		{
+			// Blocked entries are matched on normalized references -> should be rejected
+			podImages:       []string{"someuser/app"},
+			blocked:         mapset.NewThreadUnsafeSet[string]("index.docker.io/someuser"),
+			expectedIsValid: false,
+		},
+		{
+			// Other repositories of the trusted registry -> should be accepted
+			podImages:       []string{"alpine:3.20", "docker.io/someuser2/app"},
+			blocked:         mapset.NewThreadUnsafeSet[string]("docker.io/someuser"),
+			expectedIsValid: true,
+		},
+	}
+
+	for _, testCase := range cases {
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string]("docker.io"),
+			Blocked:           testCase.blocked,
+		}
+
+		response := validatePodImages(t, testCase.podImages, &settings)
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection: %s", *response.Message)
+		}
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance with pod images: %v, blocked: %v",
+				testCase.podImages, testCase.blocked)
+		}
+	}
+
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+		Blocked:           mapset.NewThreadUnsafeSet[string]("quay.io/compromised/app"),
+	}
+	response := validatePodImages(t, []string{"quay.io/compromised/app:1.0"}, &settings)
+	expected := "container 'container-0': image 'quay.io/compromised/app:1.0' is blocked by 'quay.io/compromised/app'"
+	if response.Message == nil || *response.Message != expected {
+		t.Errorf("Expected message %q, got %v", expected, response.Message)
+	}
+}
+
+func TestTrustedPatterns(t *testing.T) {
+	cases := []struct {
+		podImages       []string
+		expectedIsValid bool
+	}{
+		{[]string{"registry.corp/prod/app:1.0", "registry.corp/stage/app-2@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}, true},
+		{[]string{"registry.corp/dev/app:1.0"}, false},
+		{[]string{"registry.corp/prod/team/app:1.0"}, false},
+		// Patterns are matched against the normalized name
+		{[]string{"nginx:1.25"}, true},
+		{[]string{"quay.io/app"}, true},
+	}
+
+	for _, testCase := range cases {
+		settings := Settings{
+			TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+			TrustedPatterns: mapset.NewThreadUnsafeSet[string](
+				`^registry\.corp/(prod|stage)/[a-z0-9-]+$`,
+				`^docker\.io/library/nginx$`,
+			),
+		}
+
+		response := validatePodImages(t, testCase.podImages, &settings)
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection: %s", *response.Message)
+		}
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance with pod images: %v", testCase.podImages)
+		}
+	}
+}
+
+func TestTrustedPatternsAnchorEveryBranch(t *testing.T) {
+	settings := Settings{
+		TrustedPatterns: mapset.NewThreadUnsafeSet[string](`^registry\.corp/.*|evil`, `^quay\.io`),
+	}
+	if err := settings.compile(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	cases := []struct {
+		image    string
+		expected bool
+	}{
+		{"registry.corp/app:1.0", true},
+		{"attacker.io/evil:1.0", false},
+		{"evil/app:1.0", false},
+		// Patterns match the whole name, not a prefix of it
+		{"quay.io/app:1.0", false},
+		{"quay.io.attacker.io/app:1.0", false},
+	}
+	for _, testCase := range cases {
+		ref, err := parseNormalizedImageReference(testCase.image)
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if trusted := settings.defaultRules.trusts(ref); trusted != testCase.expected {
+			t.Errorf("Expected %s to be trusted=%v, got %v", testCase.image, testCase.expected, trusted)
+		}
+	}
+}
+
+func TestNamespaceRules(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp/shared"),
+		NamespaceRules: []NamespaceRule{
+			{
+				Namespaces:        []string{"kube-system", "monitoring-*"},
+				TrustedRegistries: []string{"registry.k8s.io", "quay.io/prometheus"},
 			},
-			Spec: &corev1.PodSpec{
-				Containers: []*corev1.Container{},
+			{
+				Namespaces:        []string{"tenant-a"},
+				TrustedRegistries: []string{"registry.corp/tenants/tenant-a/"},
 			},
+			{
+				Namespaces:      []string{"tenant-*"},
+				TrustedPatterns: []string{`^registry\.corp/tenants/shared/.*`},
+			},
+		},
+	}
+
+	cases := []struct {
+		namespace       string
+		podImages       []string
+		expectedIsValid bool
+	}{
+		{"kube-system", []string{"registry.k8s.io/pause:3.9", "quay.io/prometheus/node-exporter"}, true},
+		{"monitoring-eu", []string{"quay.io/prometheus/prometheus:v2.53.0"}, true},
+		{"monitoring-eu", []string{"registry.corp/shared/app"}, false},
+		{"tenant-a", []string{"registry.corp/tenants/tenant-a/app:1.0"}, true},
+		// The first matching rule wins, later rules are not evaluated
+		{"tenant-a", []string{"registry.corp/tenants/shared/app:1.0"}, false},
+		{"tenant-a", []string{"registry.corp/tenants/tenant-b/app:1.0"}, false},
+		{"tenant-b", []string{"registry.corp/tenants/shared/app:1.0"}, true},
+		{"tenant-b", []string{"registry.corp/tenants/tenant-b/app:1.0"}, false},
+		// Namespaces without rule fall back to the default list
+		{"default", []string{"registry.corp/shared/app"}, true},
+		{"default", []string{"registry.k8s.io/pause:3.9"}, false},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImagesInNamespace(t, testCase.namespace, testCase.podImages, &settings)
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection in namespace %s: %s", testCase.namespace, *response.Message)
 		}
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance in namespace %s with pod images: %v",
+				testCase.namespace, testCase.podImages)
+		}
+	}
 
-		for _, image := range testCase.podImages {
-			container := corev1.Container{
-				Image: image,
+	response := validatePodImagesInNamespace(t, "tenant-a", []string{"quay.io/app"}, &settings)
+	expected := "container 'container-0': image 'quay.io/app' (evaluated as 'quay.io/app:latest') " +
+		"is not from a registry trusted in namespace 'tenant-a'"
+	if response.Message == nil || *response.Message != expected {
+		t.Errorf("Expected message %q, got %v", expected, response.Message)
+	}
+}
+
+func TestNamespaceFromAdmissionRequest(t *testing.T) {
+	// request.namespace is preferred over the namespace of the object, which
+	// is usually empty on CREATE
+	payload := []byte(`{
+		"request": {
+			"kind": {"group": "", "version": "v1", "kind": "Pod"},
+			"namespace": "tenant-a",
+			"object": {
+				"metadata": {"name": "test-pod"},
+				"spec": {"containers": [{"name": "app", "image": "registry.corp/tenants/tenant-a/app"}]}
 			}
-			pod.Spec.Containers = append(pod.Spec.Containers, &container)
+		},
+		"settings": {
+			"trusted_registries": ["quay.io"],
+			"namespace_rules": [{"namespaces": ["tenant-a"], "trusted_registries": ["registry.corp/tenants/tenant-a"]}]
 		}
+	}`)
 
-		payload, err := kubewarden_testing.BuildValidationRequest(&pod, &settings)
-		if err != nil {
-			t.Errorf("Unexpected error: %+v", err)
+	response := validatePayload(t, payload)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %s", *response.Message)
+	}
+}
+
+func TestTrustedRegistryTemplates(t *testing.T) {
+	payload := func(namespace, image string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"request": {
+				"kind": {"group": "apps", "version": "v1", "kind": "Deployment"},
+				"namespace": %q,
+				"object": {
+					"metadata": {"name": "web", "labels": {"team": "search"}},
+					"spec": {"template": {
+						"metadata": {"labels": {"team": "payments"}},
+						"spec": {
+							"serviceAccountName": "builder",
+							"containers": [{"name": "app", "image": %q}]
+						}
+					}}
+				}
+			},
+			"settings": {"trusted_registries": [
+				"registry.corp/{{namespace}}/",
+				"registry.corp/teams/{{labels.team}}",
+				"registry.corp/bots/{{serviceAccount}}",
+				"registry.corp/owners/{{annotations.owner}}"
+			]}
+		}`, namespace, image))
+	}
+
+	cases := []struct {
+		namespace       string
+		image           string
+		expectedIsValid bool
+	}{
+		{"team-a", "registry.corp/team-a/app:1.0", true},
+		{"team-a", "registry.corp/team-b/app:1.0", false},
+		{"team-b", "registry.corp/teams/payments/api:2.0", true},
+		// Labels are read from the pod template, like the pods created by
+		// the controller, not from the Deployment itself
+		{"team-b", "registry.corp/teams/search/api:2.0", false},
+		{"team-b", "registry.corp/bots/builder/ci:1.0", true},
+		// The object has no owner annotation, so the entry trusts nothing
+		{"team-b", "registry.corp/owners/alice/app", false},
+	}
+
+	for _, testCase := range cases {
+		response := validatePayload(t, payload(testCase.namespace, testCase.image))
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection in namespace %s: %s", testCase.namespace, *response.Message)
+		}
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance in namespace %s of image %s", testCase.namespace, testCase.image)
+		}
+	}
+}
+
+func TestRejectionMessageShowsNormalizedImage(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+	}
+
+	response := validatePodImages(t, []string{"nginx"}, &settings)
+	if response.Accepted {
+		t.Fatalf("Unexpected acceptance")
+	}
+
+	expected := "container 'container-0': image 'nginx' (evaluated as 'docker.io/library/nginx:latest') is not from a trusted registry"
+	if response.Message == nil || *response.Message != expected {
+		t.Errorf("Expected message %q, got %v", expected, response.Message)
+	}
+}
+
+func TestRejectionMessageListsEveryViolation(t *testing.T) {
+	payload := []byte(`{
+		"request": {
+			"kind": {"group": "", "version": "v1", "kind": "Pod"},
+			"object": {
+				"metadata": {"name": "test-pod", "namespace": "default"},
+				"spec": {
+					"containers": [
+						{"name": "app", "image": "quay.io/some/app:1.0"},
+						{"name": "sidecar", "image": "gcr.io/some/proxy:2.0"}
+					],
+					"initContainers": [{"name": "setup", "image": "busybox"}],
+					"ephemeralContainers": [{"name": "debugger", "image": "quay.io/Bad"}]
+				}
+			}
+		},
+		"settings": {"trusted_registries": ["quay.io"]}
+	}`)
+
+	response := validatePayload(t, payload)
+	if response.Accepted {
+		t.Fatalf("Unexpected acceptance")
+	}
+
+	expected := "3 images are not allowed: " +
+		"container 'sidecar': image 'gcr.io/some/proxy:2.0' is not from a trusted registry; " +
+		"init container 'setup': image 'busybox' (evaluated as 'docker.io/library/busybox:latest') is not from a trusted registry; " +
+		"ephemeral container 'debugger': image 'quay.io/Bad' is not a valid image reference: " +
+		"invalid repository \"Bad\": path components must be lowercase alphanumerics separated by '.', '_', '__' or '-'"
+	if response.Message == nil || *response.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, *response.Message)
+	}
+}
+
+func TestTagPolicy(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		DisallowedTags:    mapset.NewThreadUnsafeSet[string]("latest"),
+		RejectImplicitTag: true,
+	}
+
+	cases := []struct {
+		image           string
+		expectedMessage string
+	}{
+		{"registry.corp/app:1.0", ""},
+		{"registry.corp/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ""},
+		{
+			"registry.corp/app:latest",
+			"container 'container-0': image 'registry.corp/app:latest' uses the disallowed tag 'latest'",
+		},
+		{
+			"registry.corp/app",
+			"container 'container-0': image 'registry.corp/app' (evaluated as 'registry.corp/app:latest') has no tag and defaults to 'latest'",
+		},
+		{
+			"quay.io/app:latest",
+			"container 'container-0': image 'quay.io/app:latest' is not from a trusted registry",
+		},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImages(t, []string{testCase.image}, &settings)
+		if testCase.expectedMessage == "" {
+			if !response.Accepted {
+				t.Errorf("Unexpected rejection of image %s: %v", testCase.image, *response.Message)
+			}
+			continue
 		}
+		if response.Accepted {
+			t.Errorf("Unexpected acceptance of image %s", testCase.image)
+			continue
+		}
+		if *response.Message != testCase.expectedMessage {
+			t.Errorf("Image %s: expected message %q, got %q", testCase.image, testCase.expectedMessage, *response.Message)
+		}
+	}
+}
 
-		responsePayload, err := validate(payload)
-		if err != nil {
-			t.Errorf("Unexpected error: %+v", err)
+func TestRequireDigest(t *testing.T) {
+	required := true
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("registry.corp"),
+		NamespaceRules: []NamespaceRule{
+			{Namespaces: []string{"production"}, TrustedRegistries: []string{"registry.corp"}, RequireDigest: &required},
+		},
+	}
+
+	cases := []struct {
+		namespace       string
+		image           string
+		expectedMessage string
+	}{
+		{"default", "registry.corp/app:1.0", ""},
+		{"production", "registry.corp/app@sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", ""},
+		{
+			"production", "registry.corp/app:1.0",
+			"container 'container-0': image 'registry.corp/app:1.0' is not pinned by digest",
+		},
+		{
+			"production", "registry.corp/app@sha256:1234567890abcdef",
+			"container 'container-0': image 'registry.corp/app@sha256:1234567890abcdef' is not a valid image reference: " +
+				"invalid digest \"sha256:1234567890abcdef\": sha256 digests must be 64 hexadecimal characters",
+		},
+	}
+
+	for _, testCase := range cases {
+		response := validatePodImagesInNamespace(t, testCase.namespace, []string{testCase.image}, &settings)
+		if testCase.expectedMessage == "" {
+			if !response.Accepted {
+				t.Errorf("Unexpected rejection of image %s: %v", testCase.image, *response.Message)
+			}
+			continue
 		}
+		if response.Accepted {
+			t.Errorf("Unexpected acceptance of image %s", testCase.image)
+			continue
+		}
+		if *response.Message != testCase.expectedMessage {
+			t.Errorf("Image %s: expected message %q, got %q", testCase.image, testCase.expectedMessage, *response.Message)
+		}
+	}
+}
+
+// validatePodImages runs the policy against a pod of the default namespace
+// with one container per image and returns the decoded response.
+func validatePodImages(t *testing.T, images []string, settings *Settings) kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	return validatePodImagesInNamespace(t, "default", images, settings)
+}
+
+// validatePodImagesInNamespace runs the policy against a pod of the given
+// namespace with one container per image and returns the decoded response.
+func validatePodImagesInNamespace(
+	t *testing.T, namespace string, images []string, settings *Settings,
+) kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	pod := corev1.Pod{
+		Metadata: &metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: namespace,
+		},
+		Spec: &corev1.PodSpec{
+			Containers: []*corev1.Container{},
+		},
+	}
+
+	for i, image := range images {
+		name := fmt.Sprintf("container-%d", i)
+		container := corev1.Container{
+			Name:  &name,
+			Image: image,
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, &container)
+	}
+
+	payload, err := kubewarden_testing.BuildValidationRequest(&pod, settings)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if unmarshalErr := json.Unmarshal(responsePayload, &response); unmarshalErr != nil {
+		t.Errorf("Unexpected error: %+v", unmarshalErr)
+	}
 
-		var response kubewarden_protocol.ValidationResponse
-		if unmarshalErr := json.Unmarshal(responsePayload, &response); unmarshalErr != nil {
-			t.Errorf("Unexpected error: %+v", unmarshalErr)
+	return response
+}
+
+func TestValidateWorkloadKinds(t *testing.T) {
+	cases := []struct {
+		fixture           string
+		trustedRegistries mapset.Set[string]
+		expectedIsValid   bool
+	}{
+		{"test_data/deployment.json", mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"), true},
+		{"test_data/deployment.json", mapset.NewThreadUnsafeSet[string]("quay.io"), false},
+		{"test_data/deployment.json", mapset.NewThreadUnsafeSet[string]("gcr.io"), false},
+		{"test_data/cronjob.json", mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"), true},
+		{"test_data/cronjob.json", mapset.NewThreadUnsafeSet[string]("quay.io"), false},
+		{"test_data/pod-trusted.json", mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"), true},
+		{"test_data/pod-untrusted.json", mapset.NewThreadUnsafeSet[string]("quay.io", "gcr.io"), false},
+	}
+
+	for _, testCase := range cases {
+		settings := Settings{
+			TrustedRegistries: testCase.trustedRegistries,
 		}
 
+		response := validateFixture(t, testCase.fixture, &settings)
+
 		if testCase.expectedIsValid && !response.Accepted {
-			t.Errorf("Unexpected rejection: msg %s - code %d with pod images: %v, trusted registries: %v",
-				*response.Message, *response.Code, testCase.podImages, testCase.trustedRegistries)
+			t.Errorf("Unexpected rejection of %s: %s", testCase.fixture, *response.Message)
 		}
+		if !testCase.expectedIsValid && response.Accepted {
+			t.Errorf("Unexpected acceptance of %s with trusted registries: %v",
+				testCase.fixture, testCase.trustedRegistries)
+		}
+	}
+}
 
+func TestValidateRejectsUnsupportedKind(t *testing.T) {
+	payload := []byte(`{
+		"request": {"kind": {"group": "", "version": "v1", "kind": "Service"}, "object": {}},
+		"settings": {"trusted_registries": ["quay.io"]}
+	}`)
+
+	response := validatePayload(t, payload)
+	if response.Accepted {
+		t.Errorf("Unexpected acceptance of an unsupported kind")
+	}
+}
+
+// validateFixture runs the policy against a recorded admission request and
+// returns the decoded response.
+func validateFixture(t *testing.T, fixture string, settings *Settings) kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(fixture, settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	return validatePayload(t, payload)
+}
+
+func TestValidateEphemeralContainers(t *testing.T) {
+	cases := []struct {
+		trustedRegistries mapset.Set[string]
+		expectedIsValid   bool
+	}{
+		{mapset.NewThreadUnsafeSet[string]("quay.io"), false},
+		{mapset.NewThreadUnsafeSet[string]("quay.io", "docker.io/attacker"), true},
+	}
+
+	for _, testCase := range cases {
+		settings := Settings{
+			TrustedRegistries: testCase.trustedRegistries,
+		}
+
+		response := validateFixture(t, "test_data/pod-ephemeral-containers.json", &settings)
+
+		if testCase.expectedIsValid && !response.Accepted {
+			t.Errorf("Unexpected rejection: %s", *response.Message)
+		}
 		if !testCase.expectedIsValid && response.Accepted {
-			t.Errorf("Unexpected acceptance with pod images: %v, trusted registries: %v",
-				testCase.podImages, testCase.trustedRegistries)
+			t.Errorf("Unexpected acceptance of ephemeral container with trusted registries: %v",
+				testCase.trustedRegistries)
 		}
 	}
 }
+
+func TestValidatePodUpdate(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("quay.io"),
+	}
+
+	response := validateFixture(t, "test_data/pod-update-image.json", &settings)
+	if response.Accepted {
+		t.Errorf("Unexpected acceptance of an untrusted image swapped into a running pod")
+	}
+
+	// Images the update leaves unchanged were admitted before, so relabeling
+	// a pod does not fail once its registry is no longer trusted.
+	response = validateFixture(t, "test_data/pod-update-labels.json", &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection of an update that does not change images: %v", *response.Message)
+	}
+}
+
+func TestValidatePodUpdateDoesNotMutate(t *testing.T) {
+	settings := Settings{
+		TrustedRegistries: mapset.NewThreadUnsafeSet[string]("docker.io", "mirror.corp"),
+		Mutate:            true,
+		Mirrors:           map[string]string{"quay.io": "mirror.corp/quay"},
+		PullSecrets:       map[string]string{"docker.io": "hub-pull"},
+	}
+
+	response := validateFixture(t, "test_data/pod-update-labels.json", &settings)
+	if !response.Accepted {
+		t.Errorf("Unexpected rejection: %v", *response.Message)
+	}
+	if response.MutatedObject != nil {
+		t.Errorf("Unexpected patch of a running pod: %v", response.MutatedObject)
+	}
+
+	response = validateFixture(t, "test_data/pod-update-image.json", &settings)
+	if !response.Accepted || response.MutatedObject != nil {
+		t.Errorf("Expected the image swapped into a running pod to be validated only, got accepted=%v (%v)",
+			response.Accepted, response.MutatedObject)
+	}
+}
+
+func TestValidateLegacyEphemeralContainersKind(t *testing.T) {
+	payload := []byte(`{
+		"request": {
+			"kind": {"group": "", "version": "v1", "kind": "EphemeralContainers"},
+			"subResource": "ephemeralcontainers",
+			"operation": "UPDATE",
+			"object": {
+				"metadata": {"name": "test-pod", "namespace": "default"},
+				"ephemeralContainers": [{"name": "debugger", "image": "busybox"}]
+			}
+		},
+		"settings": {"trusted_registries": ["quay.io"]}
+	}`)
+
+	response := validatePayload(t, payload)
+	if response.Accepted {
+		t.Errorf("Unexpected acceptance of an untrusted ephemeral container")
+	}
+}
+
+// validatePayload runs the policy against a raw validation request and
+// returns the decoded response.
+func validatePayload(t *testing.T, payload []byte) kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	responsePayload, err := validate(payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if unmarshalErr := json.Unmarshal(responsePayload, &response); unmarshalErr != nil {
+		t.Fatalf("Unexpected error: %+v", unmarshalErr)
+	}
+
+	return response
+}